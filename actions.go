@@ -0,0 +1,129 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// maxActionBody bounds the JSON body of POST /v1/actions.
+const maxActionBody = 8 << 10
+
+// actionBody is the JSON body of POST /v1/actions.
+type actionBody struct {
+	Action  string     `json:"action"`
+	Delay   delayValue `json:"delay,omitempty"`
+	Force   bool       `json:"force,omitempty"`
+	Message string     `json:"message,omitempty"`
+	Reason  string     `json:"reason,omitempty"`
+}
+
+// delayValue is a delay given either as a JSON number of seconds or as a
+// string in any form the delay parameter accepts.
+type delayValue string
+
+func (d *delayValue) UnmarshalJSON(data []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err == nil {
+		*d = delayValue(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("delay must be a number of seconds or a string")
+	}
+	*d = delayValue(s)
+	return nil
+}
+
+func (delayValue) openAPISchema() map[string]any {
+	return map[string]any{
+		"oneOf":       []any{map[string]any{"type": "integer", "minimum": 0}, map[string]any{"type": "string"}},
+		"description": "Seconds, or an RFC3339 time in the future.",
+	}
+}
+
+// params converts b to the parameters of the legacy routes, so both go
+// through the same validation and are recorded the same way.
+func (b actionBody) params() map[string]string {
+	params := make(map[string]string)
+	if b.Delay != "" {
+		params["delay"] = string(b.Delay)
+	}
+	if b.Force {
+		params["force"] = strconv.FormatBool(b.Force)
+	}
+	if b.Message != "" {
+		params["message"] = b.Message
+	}
+	if b.Reason != "" {
+		params["reason"] = b.Reason
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
+
+// actionInfo describes an action in the GET /v1/actions response.
+type actionInfo struct {
+	Name   string   `json:"name"`
+	Params []string `json:"params"`
+}
+
+type actionList struct {
+	Backend string       `json:"backend"`
+	Actions []actionInfo `json:"actions"`
+}
+
+// listActionsHandler serves GET /v1/actions: the enabled actions and the
+// parameters each accepts on this host.
+func listActionsHandler(enabled []string, jobs *jobManager) http.HandlerFunc {
+	list := actionList{Backend: jobs.backend.Name(), Actions: []actionInfo{}}
+	for _, action := range enabled {
+		list.Actions = append(list.Actions, actionInfo{Name: action, Params: jobs.actionParams(action)})
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+// postActionHandler serves POST /v1/actions. The permission needed and the
+// rate limit bucket depend on the requested action, so both are checked here
+// rather than by wrapping middleware.
+func postActionHandler(enabled []string, jobs *jobManager, confirm *confirmer, authz *authzPolicy, rl *rateLimiter, dryRun bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body actionBody
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxActionBody))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil {
+			status := http.StatusBadRequest
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			writeJSON(w, status, response{Status: "error", Message: fmt.Sprintf("invalid request body: %v", err)})
+			return
+		}
+		if body.Action == "" {
+			writeJSON(w, http.StatusBadRequest, response{Status: "error", Message: "action is required"})
+			return
+		}
+		if !slices.Contains(enabled, body.Action) {
+			writeJSON(w, http.StatusBadRequest, response{Status: "error", Action: body.Action, Message: fmt.Sprintf("action %q is not available on this host", body.Action)})
+			return
+		}
+		if !authz.check(w, r, body.Action) || !rl.check(w, r, limitPower, body.Action) {
+			return
+		}
+
+		runAction(w, r, jobs, confirm, authz, body.Action, body.params(), dryRun)
+	}
+}
@@ -0,0 +1,253 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+type auditConfig struct {
+	File string `yaml:"file"`
+}
+
+// auditEntry is one line of the audit log. Each entry's hash covers the
+// previous entry's hash, so editing, removing or reordering lines breaks the
+// chain from that point on.
+type auditEntry struct {
+	Seq         uint64            `json:"seq"`
+	Time        time.Time         `json:"time"`
+	CN          string            `json:"cn,omitempty"`
+	Fingerprint string            `json:"fingerprint,omitempty"`
+	RemoteAddr  string            `json:"remote_addr,omitempty"`
+	RequestID   string            `json:"request_id,omitempty"`
+	Action      string            `json:"action"`
+	Params      map[string]string `json:"params,omitempty"`
+	DryRun      bool              `json:"dry_run"`
+	JobID       string            `json:"job_id,omitempty"`
+	Outcome     string            `json:"outcome"`
+	Error       string            `json:"error,omitempty"`
+	PrevHash    string            `json:"prev_hash"`
+	Hash        string            `json:"hash,omitempty"`
+}
+
+// auditHead is stored next to the log and records the last entry, so that
+// lines removed from the end of the log can be detected too.
+type auditHead struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// auditLog appends hash-chained JSON lines to a file. A nil *auditLog
+// discards entries, which is the behaviour when no audit file is configured.
+type auditLog struct {
+	path string
+
+	mu   sync.Mutex
+	f    *os.File
+	seq  uint64
+	last string
+}
+
+// openAuditLog opens the log at path for appending. An existing log must
+// pass verifyAuditLog first: extending a broken chain would make the damage
+// look like part of the record.
+func openAuditLog(path string) (*auditLog, error) {
+	a := &auditLog{path: path}
+
+	// Continue the chain from the last entry already in the file
+	entries, warnings, err := verifyAuditLog(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if _, err := os.Stat(auditHeadPath(path)); err == nil {
+			return nil, fmt.Errorf("audit log %s is missing but %s exists; restore the log, or move the head file aside to start a new chain", path, auditHeadPath(path))
+		}
+	case err != nil:
+		return nil, fmt.Errorf("audit log %s failed verification; move it and its head file aside to start a new chain: %w", path, err)
+	default:
+		for _, w := range warnings {
+			slog.Warn("audit log check", "path", path, "warning", w)
+		}
+		if len(entries) > 0 {
+			last := entries[len(entries)-1]
+			a.seq, a.last = last.Seq, last.Hash
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	a.f = f
+	return a, nil
+}
+
+// record fills in the sequence number, time and hashes of e and appends it.
+func (a *auditLog) record(e auditEntry) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e.Seq = a.seq + 1
+	e.Time = time.Now().UTC()
+	e.PrevHash = a.last
+	hash, err := e.computeHash()
+	if err != nil {
+		slog.Error("failed to encode audit entry", "error", err)
+		return
+	}
+	e.Hash = hash
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("failed to encode audit entry", "error", err)
+		return
+	}
+	if _, err := a.f.Write(append(line, '\n')); err != nil {
+		slog.Error("failed to write audit entry", "error", err)
+		return
+	}
+	if err := a.f.Sync(); err != nil {
+		slog.Error("failed to sync audit log", "error", err)
+	}
+	a.seq, a.last = e.Seq, e.Hash
+
+	if err := writeAuditHead(a.path, auditHead{Seq: e.Seq, Hash: e.Hash}); err != nil {
+		slog.Error("failed to update audit head", "error", err)
+	}
+}
+
+// computeHash returns the hex SHA-256 of the entry encoded without its hash.
+// PrevHash is part of the encoding, which is what chains the entries.
+func (e auditEntry) computeHash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func auditHeadPath(path string) string {
+	return path + ".head"
+}
+
+func writeAuditHead(path string, head auditHead) error {
+	data, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+	tmp := auditHeadPath(path) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, auditHeadPath(path))
+}
+
+func readAuditEntries(path string) ([]auditEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []auditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		dec := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		dec.DisallowUnknownFields()
+		var e auditEntry
+		if err := dec.Decode(&e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// verifyAuditLog checks the hash chain of the log at path and, if a head file
+// exists, that no entries are missing from the end. It returns the entries.
+func verifyAuditLog(path string) (entries []auditEntry, warnings []string, err error) {
+	entries, err = readAuditEntries(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prev := ""
+	for i, e := range entries {
+		line := i + 1
+		if e.Seq != uint64(line) {
+			return nil, nil, fmt.Errorf("line %d: sequence number is %d, expected %d (entries removed or reordered)", line, e.Seq, line)
+		}
+		if e.PrevHash != prev {
+			return nil, nil, fmt.Errorf("line %d: previous hash does not match line %d (entries removed or reordered)", line, line-1)
+		}
+		hash, err := e.computeHash()
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if hash != e.Hash {
+			return nil, nil, fmt.Errorf("line %d: hash mismatch (entry modified)", line)
+		}
+		prev = e.Hash
+	}
+
+	data, err := os.ReadFile(auditHeadPath(path))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		warnings = append(warnings, "no head file found; entries removed from the end cannot be detected")
+	case err != nil:
+		return nil, nil, err
+	default:
+		var head auditHead
+		if err := json.Unmarshal(data, &head); err != nil {
+			return nil, nil, fmt.Errorf("invalid head file: %w", err)
+		}
+		n := uint64(len(entries))
+		switch {
+		case head.Seq == n && head.Hash == prev:
+		case head.Seq > n:
+			return nil, nil, fmt.Errorf("log ends at entry %d but head records entry %d (log truncated)", n, head.Seq)
+		case head.Seq > 0 && head.Seq+1 == n && entries[head.Seq-1].Hash == head.Hash:
+			// Entries are synced before the head is replaced, so a crash in
+			// between leaves the head one entry behind
+			warnings = append(warnings, fmt.Sprintf("head records entry %d of %d; the last head update was lost", head.Seq, n))
+		default:
+			return nil, nil, fmt.Errorf("head records entry %d, which does not match the log (log rewritten)", head.Seq)
+		}
+	}
+	return entries, warnings, nil
+}
+
+// auditCommand implements "winshut audit verify <file>".
+func auditCommand(args []string) {
+	if len(args) != 2 || args[0] != "verify" {
+		fmt.Fprintln(os.Stderr, "usage: winshut audit verify <audit-log>")
+		os.Exit(1)
+	}
+
+	entries, warnings, err := verifyAuditLog(args[1])
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: audit log %s failed verification: %v\n", args[1], err)
+		os.Exit(1)
+	}
+	fmt.Printf("audit log %s ok: %d entries\n", args[1], len(entries))
+}
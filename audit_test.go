@@ -0,0 +1,154 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestAudit records n entries in a new audit log and returns its path.
+func writeTestAudit(t *testing.T, n int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a, err := openAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.f.Close()
+	for i := range n {
+		a.record(auditEntry{CN: "client", Action: "shutdown", JobID: string(rune('a' + i)), Outcome: "accepted"})
+	}
+	return path
+}
+
+// editLines rewrites the log at path with edit applied to its lines.
+func editLines(t *testing.T, path string, edit func(lines [][]byte) [][]byte) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := edit(bytes.SplitAfter(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")))
+	if err := os.WriteFile(path, bytes.Join(lines, nil), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(path string)
+		want string // error, or "" if the log must verify
+	}{
+		{
+			name: "clean",
+			edit: func(string) {},
+		},
+		{
+			name: "edited entry",
+			edit: func(path string) {
+				editLines(t, path, func(lines [][]byte) [][]byte {
+					lines[1] = bytes.Replace(lines[1], []byte(`"shutdown"`), []byte(`"restart"`), 1)
+					return lines
+				})
+			},
+			want: "line 2: hash mismatch",
+		},
+		{
+			name: "deleted middle line",
+			edit: func(path string) {
+				editLines(t, path, func(lines [][]byte) [][]byte { return append(lines[:1], lines[2:]...) })
+			},
+			want: "line 2: sequence number is 3",
+		},
+		{
+			name: "truncated tail",
+			edit: func(path string) {
+				editLines(t, path, func(lines [][]byte) [][]byte { return lines[:2] })
+			},
+			want: "log truncated",
+		},
+		{
+			name: "rewritten head",
+			edit: func(path string) {
+				if err := writeAuditHead(path, auditHead{Seq: 3, Hash: strings.Repeat("0", 64)}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "log rewritten",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestAudit(t, 3)
+			tt.edit(path)
+
+			entries, warnings, err := verifyAuditLog(path)
+			if tt.want == "" {
+				if err != nil || len(warnings) > 0 || len(entries) != 3 {
+					t.Fatalf("got %d entries, warnings %v, error %v; want 3 entries", len(entries), warnings, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.want)
+			}
+			// The server must not extend a chain that fails verification
+			if _, err := openAuditLog(path); err == nil {
+				t.Error("openAuditLog accepted a log that fails verification")
+			}
+		})
+	}
+}
+
+func TestOpenAuditLogContinuesChain(t *testing.T) {
+	path := writeTestAudit(t, 2)
+	a, err := openAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.record(auditEntry{Action: "restart", Outcome: "accepted"})
+	a.f.Close()
+
+	entries, _, err := verifyAuditLog(path)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("got %d entries, error %v; want 3 entries", len(entries), err)
+	}
+}
+
+// A crash between syncing an entry and replacing the head leaves the head one
+// entry behind, which is not tampering.
+func TestVerifyAuditLogLaggingHead(t *testing.T) {
+	path := writeTestAudit(t, 3)
+	entries, _, err := verifyAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeAuditHead(path, auditHead{Seq: 2, Hash: entries[1].Hash}); err != nil {
+		t.Fatal(err)
+	}
+	if _, warnings, err := verifyAuditLog(path); err != nil || len(warnings) != 1 {
+		t.Fatalf("got warnings %v, error %v; want one warning", warnings, err)
+	}
+	a, err := openAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.f.Close()
+}
+
+func TestOpenAuditLogMissingLog(t *testing.T) {
+	path := writeTestAudit(t, 1)
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openAuditLog(path); err == nil || !strings.Contains(err.Error(), "is missing") {
+		t.Fatalf("got error %v, want one saying the log is missing", err)
+	}
+}
@@ -5,29 +5,69 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 )
 
-func authMiddleware(next http.Handler) http.Handler {
+// clientIdentity is the verified client certificate behind a request.
+type clientIdentity struct {
+	Cert        *x509.Certificate
+	Fingerprint string // hex SHA-256 of the DER certificate
+}
+
+type identityKey struct{}
+
+func identityFromContext(ctx context.Context) *clientIdentity {
+	id, _ := ctx.Value(identityKey{}).(*clientIdentity)
+	return id
+}
+
+// requester describes who made a request, for job records and the audit log.
+type requester struct {
+	CN          string
+	Fingerprint string
+	RemoteAddr  string
+	RequestID   string
+}
+
+func requesterFromRequest(r *http.Request) requester {
+	by := requester{RemoteAddr: r.RemoteAddr, RequestID: requestIDFromContext(r.Context())}
+	if id := identityFromContext(r.Context()); id != nil {
+		by.CN = id.Cert.Subject.CommonName
+		by.Fingerprint = id.Fingerprint
+	}
+	return by
+}
+
+// authMiddleware requires a verified client certificate, counting requests
+// without one towards a ban of their source IP.
+func authMiddleware(bans *banList, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
 			cert := r.TLS.VerifiedChains[0][0]
 			fp := sha256.Sum256(cert.Raw)
-			log.Printf("auth cn=%s fp=%x from %s", cert.Subject.CommonName, fp[:8], r.RemoteAddr)
-			next.ServeHTTP(w, r)
+			slog.InfoContext(r.Context(), "authenticated", "cn", cert.Subject.CommonName, "fingerprint", hex.EncodeToString(fp[:8]), "remote_addr", r.RemoteAddr, "method", r.Method, "path", r.URL.Path)
+			id := &clientIdentity{Cert: cert, Fingerprint: hex.EncodeToString(fp[:])}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
 			return
 		}
 
-		log.Printf("auth failed from %s", r.RemoteAddr)
+		slog.WarnContext(r.Context(), "no verified client certificate", "remote_addr", r.RemoteAddr)
+		metrics.inc("winshut_auth_failures_total")
+		if bans.fail(r.RemoteAddr, failUnauthorized) {
+			w.Header().Set("Connection", "close")
+		}
 		writeJSON(w, http.StatusUnauthorized, response{Status: "error", Message: "unauthorized"})
 	})
 }
 
-func allowlistMiddleware(cidrs []*net.IPNet, next http.Handler) http.Handler {
+func allowlistMiddleware(cidrs []*net.IPNet, bans *banList, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		host, _, err := net.SplitHostPort(r.RemoteAddr)
 		if err != nil {
@@ -41,7 +81,11 @@ func allowlistMiddleware(cidrs []*net.IPNet, next http.Handler) http.Handler {
 				return
 			}
 		}
-		log.Printf("blocked %s (not in allowlist)", r.RemoteAddr)
+		slog.WarnContext(r.Context(), "blocked by allowlist", "remote_addr", r.RemoteAddr)
+		metrics.inc("winshut_allowlist_blocks_total")
+		if bans.fail(r.RemoteAddr, failAllowlist) {
+			w.Header().Set("Connection", "close")
+		}
 		writeJSON(w, http.StatusForbidden, response{Status: "error", Message: fmt.Sprintf("forbidden: %s not in allowlist", host)})
 	})
 }
@@ -0,0 +1,173 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// endpointPermissions are the permissions for non-power endpoints, plus
+// "force" for overriding inhibitors. Every power action is also a permission
+// of the same name, and "*" grants everything.
+var endpointPermissions = []string{"stats", "metrics", "certs", "jobs", "cancel", "wake", "bans", "force"}
+
+type authzConfig struct {
+	Roles        map[string][]string `yaml:"roles"`
+	Identities   []identityRule      `yaml:"identities"`
+	DefaultRoles []string            `yaml:"default_roles"`
+}
+
+// identityRule grants roles to client certificates. Every selector that is
+// set must match; a certificate collects the roles of all matching rules.
+type identityRule struct {
+	CN          string   `yaml:"cn"`
+	SAN         string   `yaml:"san"`
+	OU          string   `yaml:"ou"`
+	Fingerprint string   `yaml:"fingerprint"`
+	Roles       []string `yaml:"roles"`
+}
+
+func (c *authzConfig) enabled() bool {
+	return len(c.Roles) > 0 || len(c.Identities) > 0
+}
+
+func (c *authzConfig) validate() error {
+	var errs []error
+	for role, perms := range c.Roles {
+		for _, p := range perms {
+			if !knownPermission(p) {
+				errs = append(errs, fmt.Errorf("authz.roles.%s: unknown permission %q", role, p))
+			}
+		}
+	}
+	for i, rule := range c.Identities {
+		if rule.CN == "" && rule.SAN == "" && rule.OU == "" && rule.Fingerprint == "" {
+			errs = append(errs, fmt.Errorf("authz.identities[%d]: at least one of cn, san, ou or fingerprint is required", i))
+		}
+		if rule.Fingerprint != "" && !validFingerprint(rule.Fingerprint) {
+			errs = append(errs, fmt.Errorf("authz.identities[%d].fingerprint: must be a hex SHA-256 digest", i))
+		}
+		if len(rule.Roles) == 0 {
+			errs = append(errs, fmt.Errorf("authz.identities[%d].roles: at least one role is required", i))
+		}
+		for _, role := range rule.Roles {
+			if _, ok := c.Roles[role]; !ok {
+				errs = append(errs, fmt.Errorf("authz.identities[%d].roles: undefined role %q", i, role))
+			}
+		}
+	}
+	for _, role := range c.DefaultRoles {
+		if _, ok := c.Roles[role]; !ok {
+			errs = append(errs, fmt.Errorf("authz.default_roles: undefined role %q", role))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func knownPermission(p string) bool {
+	return p == "*" || slices.Contains(powerActions, p) || slices.Contains(endpointPermissions, p)
+}
+
+func normalizeFingerprint(fp string) string {
+	return strings.ToLower(strings.ReplaceAll(fp, ":", ""))
+}
+
+// authzPolicy enforces authzConfig. A nil policy allows every authenticated
+// client, which is the behaviour when no authz section is configured.
+type authzPolicy struct {
+	cfg authzConfig
+}
+
+func newAuthzPolicy(cfg authzConfig) *authzPolicy {
+	if !cfg.enabled() {
+		return nil
+	}
+	return &authzPolicy{cfg: cfg}
+}
+
+func (p *authzPolicy) roles(id *clientIdentity) []string {
+	roles := slices.Clone(p.cfg.DefaultRoles)
+	for _, rule := range p.cfg.Identities {
+		if rule.matches(id) {
+			roles = append(roles, rule.Roles...)
+		}
+	}
+	return roles
+}
+
+func (p *authzPolicy) allowed(id *clientIdentity, perm string) bool {
+	if p == nil {
+		return true
+	}
+	if id == nil {
+		return false
+	}
+	for _, role := range p.roles(id) {
+		perms := p.cfg.Roles[role]
+		if slices.Contains(perms, perm) || slices.Contains(perms, "*") {
+			return true
+		}
+	}
+	return false
+}
+
+// require wraps next so that it only runs for identities holding perm. It must
+// be installed inside authMiddleware.
+func (p *authzPolicy) require(perm string, next http.Handler) http.Handler {
+	if p == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.check(w, r, perm) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// check reports whether the request's identity holds perm and writes a 403
+// response if not. It is for handlers that only learn the permission they
+// need from the request body.
+func (p *authzPolicy) check(w http.ResponseWriter, r *http.Request, perm string) bool {
+	id := identityFromContext(r.Context())
+	if p.allowed(id, perm) {
+		return true
+	}
+	if id != nil {
+		slog.WarnContext(r.Context(), "permission denied", "cn", id.Cert.Subject.CommonName, "fingerprint", id.Fingerprint[:16], "permission", perm)
+	}
+	writeJSON(w, http.StatusForbidden, response{Status: "error", Message: fmt.Sprintf("forbidden: missing permission %q", perm)})
+	return false
+}
+
+func (rule *identityRule) matches(id *clientIdentity) bool {
+	cert := id.Cert
+	if rule.CN != "" && rule.CN != cert.Subject.CommonName {
+		return false
+	}
+	if rule.OU != "" && !slices.Contains(cert.Subject.OrganizationalUnit, rule.OU) {
+		return false
+	}
+	if rule.Fingerprint != "" && normalizeFingerprint(rule.Fingerprint) != id.Fingerprint {
+		return false
+	}
+	if rule.SAN != "" {
+		sans := slices.Concat(cert.DNSNames, cert.EmailAddresses)
+		for _, ip := range cert.IPAddresses {
+			sans = append(sans, ip.String())
+		}
+		for _, uri := range cert.URIs {
+			sans = append(sans, uri.String())
+		}
+		if !slices.Contains(sans, rule.SAN) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,302 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Failures that count towards a ban.
+const (
+	failHandshake    = "handshake"    // TLS handshake failed over the client certificate, e.g. none or untrusted
+	failUnauthorized = "unauthorized" // request without a verified client certificate
+	failAllowlist    = "allowlist"    // request from outside the allowlist
+)
+
+type banConfig struct {
+	MaxFailures int           `yaml:"max_failures"` // failures within window that get an IP banned; 0 disables banning
+	Window      time.Duration `yaml:"window"`
+	Duration    time.Duration `yaml:"duration"` // how long a ban lasts
+	Exempt      []string      `yaml:"exempt"`   // CIDRs that are never banned
+}
+
+func (c *banConfig) enabled() bool {
+	return c.MaxFailures > 0
+}
+
+func (c *banConfig) validate() error {
+	var errs []error
+	if c.MaxFailures < 0 {
+		errs = append(errs, fmt.Errorf("ban.max_failures: must not be negative, got %d", c.MaxFailures))
+	}
+	if c.Window <= 0 {
+		errs = append(errs, fmt.Errorf("ban.window: must be positive, got %s", c.Window))
+	}
+	if c.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("ban.duration: must be positive, got %s", c.Duration))
+	}
+	for i, s := range c.Exempt {
+		if _, _, err := net.ParseCIDR(strings.TrimSpace(s)); err != nil {
+			errs = append(errs, fmt.Errorf("ban.exempt[%d]: invalid CIDR %q", i, s))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ban is a source IP whose connections are closed before the TLS handshake.
+type ban struct {
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason"` // the failure that triggered the ban
+	Failures  int       `json:"failures"`
+	BannedAt  time.Time `json:"banned_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// banList counts failures per source IP and bans an IP for a while once it
+// has failed too often within the window. A nil *banList bans nothing.
+type banList struct {
+	cfg    banConfig
+	exempt []*net.IPNet
+
+	mu        sync.Mutex
+	failures  map[string][]time.Time // recent failures by IP, oldest first
+	bans      map[string]*ban
+	lastSweep time.Time
+}
+
+func newBanList(cfg banConfig) (*banList, error) {
+	if !cfg.enabled() {
+		return nil, nil
+	}
+	exempt, err := parseCIDRs(cfg.Exempt)
+	if err != nil {
+		return nil, err
+	}
+	return &banList{
+		cfg:       cfg,
+		exempt:    exempt,
+		failures:  make(map[string][]time.Time),
+		bans:      make(map[string]*ban),
+		lastSweep: time.Now(),
+	}, nil
+}
+
+// hostIP returns the IP of a host:port address, or addr itself if it has no
+// port.
+func hostIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// fail records a failure of the given kind from addr and reports whether it
+// got addr banned.
+func (b *banList) fail(addr, reason string) bool {
+	if b == nil {
+		return false
+	}
+	ip := hostIP(addr)
+	if parsed := net.ParseIP(ip); parsed == nil || slices.ContainsFunc(b.exempt, func(n *net.IPNet) bool { return n.Contains(parsed) }) {
+		return false
+	}
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now.Sub(b.lastSweep) >= b.cfg.Window {
+		b.sweep(now)
+	}
+	if _, ok := b.bans[ip]; ok {
+		return false
+	}
+	recent := append(b.recent(ip, now), now)
+	if len(recent) < b.cfg.MaxFailures {
+		b.failures[ip] = recent
+		return false
+	}
+	delete(b.failures, ip)
+	b.bans[ip] = &ban{IP: ip, Reason: reason, Failures: len(recent), BannedAt: now, ExpiresAt: now.Add(b.cfg.Duration)}
+	slog.Warn("banned client", "ip", ip, "reason", reason, "failures", len(recent), "window", b.cfg.Window, "duration", b.cfg.Duration)
+	metrics.inc("winshut_bans_total", "reason", reason)
+	return true
+}
+
+// recent returns the failures from ip within the window. b.mu must be held.
+func (b *banList) recent(ip string, now time.Time) []time.Time {
+	times := b.failures[ip]
+	i, _ := slices.BinarySearchFunc(times, now.Add(-b.cfg.Window), time.Time.Compare)
+	return times[i:]
+}
+
+// sweep drops expired bans and failures that have left the window, so that
+// scanners cycling through addresses don't grow the maps forever. b.mu must
+// be held.
+func (b *banList) sweep(now time.Time) {
+	for ip, bn := range b.bans {
+		if !now.Before(bn.ExpiresAt) {
+			delete(b.bans, ip)
+		}
+	}
+	for ip := range b.failures {
+		if recent := b.recent(ip, now); len(recent) > 0 {
+			b.failures[ip] = recent
+		} else {
+			delete(b.failures, ip)
+		}
+	}
+	b.lastSweep = now
+}
+
+// banned reports whether ip is currently banned.
+func (b *banList) banned(ip string) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bn, ok := b.bans[ip]
+	if ok && !time.Now().Before(bn.ExpiresAt) {
+		delete(b.bans, ip)
+		return false
+	}
+	return ok
+}
+
+// list returns the current bans, soonest to expire first.
+func (b *banList) list() []ban {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sweep(time.Now())
+	out := make([]ban, 0, len(b.bans))
+	for _, bn := range b.bans {
+		out = append(out, *bn)
+	}
+	slices.SortFunc(out, func(x, y ban) int {
+		if c := x.ExpiresAt.Compare(y.ExpiresAt); c != 0 {
+			return c
+		}
+		return strings.Compare(x.IP, y.IP)
+	})
+	return out
+}
+
+// unban lifts the ban on ip, or every ban if ip is empty, and forgets the
+// failures recorded for it. It returns the IPs that were banned.
+func (b *banList) unban(ip string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var lifted []string
+	for banned := range b.bans {
+		if ip == "" || banned == ip {
+			delete(b.bans, banned)
+			lifted = append(lifted, banned)
+		}
+	}
+	if ip == "" {
+		clear(b.failures)
+	} else {
+		delete(b.failures, ip)
+	}
+	slices.Sort(lifted)
+	return lifted
+}
+
+// listener wraps ln so that connections from banned IPs are closed as soon
+// as they are accepted, before the TLS handshake.
+func (b *banList) listener(ln net.Listener) net.Listener {
+	if b == nil {
+		return ln
+	}
+	return &banListener{Listener: ln, bans: b}
+}
+
+type banListener struct {
+	net.Listener
+	bans *banList
+}
+
+func (l *banListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if !l.bans.banned(hostIP(c.RemoteAddr().String())) {
+			return c, nil
+		}
+		metrics.inc("winshut_banned_connections_total")
+		c.Close()
+	}
+}
+
+// handshakeErrorPrefix starts the message net/http logs for a failed TLS
+// handshake, followed by "host:port: error".
+const handshakeErrorPrefix = "http: TLS handshake error from "
+
+// errorLog returns a logger for http.Server.ErrorLog that counts TLS
+// handshakes failed by certificateError as failures, since the server never
+// sees a request for them, and passes every message on to slog like the
+// standard logger would.
+func (b *banList) errorLog() *log.Logger {
+	return log.New(serverErrorWriter{b}, "", 0)
+}
+
+type serverErrorWriter struct {
+	bans *banList
+}
+
+func (w serverErrorWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	if rest, ok := strings.CutPrefix(msg, handshakeErrorPrefix); ok {
+		addr, err, _ := strings.Cut(rest, ": ")
+		if certificateError(err) {
+			w.bans.fail(addr, failHandshake)
+		}
+	}
+	slog.Warn(msg)
+	return len(p), nil
+}
+
+// certificateError reports whether a handshake failed over the client's
+// certificate: none sent, or one that is untrusted, expired, revoked or
+// denied. Other handshake errors aren't counted: an EOF or reset from a port
+// scan or a TCP health check, or plain HTTP, says nothing about whether the
+// peer holds a cert, and a "remote error" is the client rejecting ours.
+func certificateError(err string) bool {
+	return strings.Contains(err, "certificate") && !strings.HasPrefix(err, "remote error: ")
+}
+
+// listHandler serves the current bans.
+func (b *banList) listHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, b.list())
+}
+
+// unbanHandler lifts the ban on the {ip} path value, or every ban if the
+// route has none.
+func (b *banList) unbanHandler(w http.ResponseWriter, r *http.Request) {
+	ip := r.PathValue("ip")
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+	lifted := b.unban(ip)
+	if ip != "" && len(lifted) == 0 {
+		writeJSON(w, http.StatusNotFound, response{Status: "error", Message: ip + " is not banned"})
+		return
+	}
+	by := requesterFromRequest(r)
+	slog.InfoContext(r.Context(), "bans lifted", "ips", lifted, "cn", by.CN, "remote_addr", by.RemoteAddr)
+	writeJSON(w, http.StatusOK, response{Status: "ok", Message: fmt.Sprintf("lifted %d ban(s)", len(lifted))})
+}
@@ -0,0 +1,96 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCertificateError(t *testing.T) {
+	tests := []struct {
+		err  string
+		want bool
+	}{
+		{"tls: client didn't provide a certificate", true},
+		{"tls: failed to verify certificate: x509: certificate signed by unknown authority", true},
+		{"client certificate has been revoked", true},
+		{"client certificate is on the deny list", true},
+		{"EOF", false},
+		{"read tcp 127.0.0.1:9090->127.0.0.1:51234: read: connection reset by peer", false},
+		{"tls: first record does not look like a TLS handshake", false},
+		{"tls: client offered only unsupported versions: [303 302 301]", false},
+		{"remote error: tls: bad certificate", false},
+	}
+	for _, tt := range tests {
+		if got := certificateError(tt.err); got != tt.want {
+			t.Errorf("certificateError(%q) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// Connections that close without a handshake don't count towards a ban, and
+// handshakes without a client certificate do.
+func TestHandshakeFailureBans(t *testing.T) {
+	dir := testPKI(t, "client")
+	cfg := testServerConfig(dir)
+	cfg.Ban.MaxFailures = 3
+	addr := strings.TrimPrefix(startTestServer(t, cfg), "https://")
+	c := testClient(t, dir, "client")
+	// A new connection for every request, so that a ban refuses the next one
+	c.Transport.(*http.Transport).DisableKeepAlives = true
+
+	for range 5 {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+	// Give the server time to log the probes before checking they didn't count
+	time.Sleep(100 * time.Millisecond)
+	if resp, err := c.Get("https://" + addr + "/health"); err != nil {
+		t.Fatalf("banned after connections that closed without a handshake: %v", err)
+	} else {
+		resp.Body.Close()
+	}
+
+	caPEM, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caPEM)
+	for range cfg.Ban.MaxFailures {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool})
+		if err != nil {
+			continue
+		}
+		// In TLS 1.3 the server rejects the missing certificate after the
+		// client's side of the handshake is done, so read to see it
+		conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := c.Get("https://" + addr + "/health")
+		if err != nil {
+			return
+		}
+		resp.Body.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("not banned after handshakes without a client certificate")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
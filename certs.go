@@ -0,0 +1,209 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader holds the server keypair and client CA pool and swaps them in
+// place when the files on disk change, so new connections pick up rotated
+// certs without a restart. If the new files are invalid the previous material
+// keeps being served.
+type certReloader struct {
+	files tlsFileConfig
+	base  *tls.Config
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	caCerts []*x509.Certificate
+	caPool  *x509.CertPool
+	stamp   string
+}
+
+func newCertReloader(files tlsFileConfig, base *tls.Config) (*certReloader, error) {
+	r := &certReloader{files: files, base: base}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	r.stamp = r.fileStamp()
+	return r, nil
+}
+
+// tlsConfig returns the config to install on the http.Server. Every handshake
+// goes through GetConfigForClient and so sees the current cert and CA pool.
+func (r *certReloader) tlsConfig() *tls.Config {
+	cfg := r.base.Clone()
+	cfg.GetCertificate = r.getCertificate
+	cfg.GetConfigForClient = r.getConfigForClient
+	return cfg
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+func (r *certReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cfg := r.base.Clone()
+	cfg.Certificates = []tls.Certificate{*r.cert}
+	cfg.ClientCAs = r.caPool
+	cfg.VerifyConnection = verifyResumedChain(r.caPool, r.base.VerifyConnection)
+	return cfg, nil
+}
+
+// verifyResumedChain returns a VerifyConnection that checks the client chain
+// of a resumed session against pool before calling next. A full handshake
+// verifies the chain against ClientCAs, but older Go releases resume a
+// session on the strength of its ticket alone, which lets a client whose CA
+// has since been replaced keep connecting.
+func verifyResumedChain(pool *x509.CertPool, next func(tls.ConnectionState) error) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if cs.DidResume && len(cs.PeerCertificates) > 0 {
+			opts := x509.VerifyOptions{
+				Roots:         pool,
+				Intermediates: x509.NewCertPool(),
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			}
+			for _, c := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(c)
+			}
+			if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+				return fmt.Errorf("resumed session: %w", err)
+			}
+		}
+		if next != nil {
+			return next(cs)
+		}
+		return nil
+	}
+}
+
+// current returns the server certificate and client CA certificates in use.
+func (r *certReloader) current() (*x509.Certificate, []*x509.Certificate) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert.Leaf, r.caCerts
+}
+
+// currentCACerts returns the client CA certificates currently in use.
+func (r *certReloader) currentCACerts() []*x509.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.caCerts
+}
+
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load server keypair: %w", err)
+	}
+
+	caPEM, err := os.ReadFile(r.files.CAFile)
+	if err != nil {
+		return fmt.Errorf("failed to read CA file: %w", err)
+	}
+	caCerts, err := parsePEMCerts(caPEM)
+	if err != nil {
+		return fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	caPool := x509.NewCertPool()
+	for _, c := range caCerts {
+		caPool.AddCert(c)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.caCerts = caCerts
+	r.caPool = caPool
+	r.mu.Unlock()
+
+	fp := sha256.Sum256(cert.Leaf.Raw)
+	slog.Info("loaded server cert", "cn", cert.Leaf.Subject.CommonName, "fingerprint", hex.EncodeToString(fp[:8]), "expires", cert.Leaf.NotAfter.Format(time.RFC3339))
+	for _, c := range caCerts {
+		fp := sha256.Sum256(c.Raw)
+		slog.Info("loaded CA cert", "cn", c.Subject.CommonName, "fingerprint", hex.EncodeToString(fp[:8]), "expires", c.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// watch polls the cert, key and CA files every interval and reloads them when
+// any of them changes, and also reloads unconditionally whenever a value
+// arrives on trigger (SIGHUP on Unix). An interval of zero disables polling.
+func (r *certReloader) watch(interval time.Duration, trigger <-chan os.Signal) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for {
+		select {
+		case <-tick:
+			stamp := r.fileStamp()
+			if stamp == r.stamp {
+				continue
+			}
+			r.stamp = stamp
+			slog.Info("TLS files changed, reloading")
+		case <-trigger:
+			r.stamp = r.fileStamp()
+			slog.Info("reload requested, reloading TLS files")
+		}
+		if err := r.reload(); err != nil {
+			slog.Error("TLS reload failed, keeping previous certs", "error", err)
+		}
+	}
+}
+
+// fileStamp summarises the size and modification time of the watched files.
+func (r *certReloader) fileStamp() string {
+	var stamp string
+	for _, path := range []string{r.files.CertFile, r.files.KeyFile, r.files.CAFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			stamp += path + ":missing;"
+			continue
+		}
+		stamp += fmt.Sprintf("%s:%d:%d;", path, info.Size(), info.ModTime().UnixNano())
+	}
+	return stamp
+}
+
+func parsePEMCerts(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+	return certs, nil
+}
@@ -0,0 +1,102 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCAReloadAppliesToResumedSessions(t *testing.T) {
+	dir := testPKI(t, "client")
+	cfg := testServerConfig(dir)
+	cfg.TLS.ReloadInterval = 20 * time.Millisecond
+	url := startTestServer(t, cfg)
+
+	c := testClient(t, dir, "client")
+	// A new connection for every request, so every request is a handshake
+	c.Transport.(*http.Transport).DisableKeepAlives = true
+	get := func() (*http.Response, error) {
+		resp, err := c.Get(url + "/health")
+		if err == nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	for i := range 2 {
+		resp, err := get()
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if i == 1 && !resp.TLS.DidResume {
+			t.Fatal("second connection did not resume the TLS session")
+		}
+	}
+
+	// Replace the client CA with one that didn't issue the client's cert
+	other := testPKI(t)
+	caPEM, err := os.ReadFile(filepath.Join(other, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ca.crt"), caPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := get()
+		if err != nil {
+			return
+		}
+		if !resp.TLS.DidResume {
+			t.Fatal("connection did not resume the TLS session")
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cert from the old CA still accepted on a resumed session: got %d", resp.StatusCode)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// Newer Go releases already decline tickets whose chain no longer verifies,
+// so check verifyResumedChain directly as well.
+func TestVerifyResumedChain(t *testing.T) {
+	dir := testPKI(t, "client")
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := func(dir string) *x509.CertPool {
+		caPEM, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := x509.NewCertPool()
+		p.AppendCertsFromPEM(caPEM)
+		return p
+	}
+	errNext := errors.New("next")
+	next := func(tls.ConnectionState) error { return errNext }
+	cs := tls.ConnectionState{DidResume: true, PeerCertificates: []*x509.Certificate{cert.Leaf}}
+
+	if err := verifyResumedChain(pool(dir), next)(cs); !errors.Is(err, errNext) {
+		t.Errorf("issuing CA: got %v, want the error from next", err)
+	}
+	if err := verifyResumedChain(pool(testPKI(t)), next)(cs); err == nil || errors.Is(err, errNext) {
+		t.Errorf("other CA: got %v, want a verification error", err)
+	}
+	cs.DidResume = false
+	if err := verifyResumedChain(pool(testPKI(t)), nil)(cs); err != nil {
+		t.Errorf("full handshake: got %v, want nil", err)
+	}
+}
@@ -0,0 +1,260 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Response is the server's reply to most requests.
+type Response struct {
+	Status  string `json:"status"`
+	Action  string `json:"action,omitempty"`
+	Message string `json:"message,omitempty"`
+	JobID   string `json:"job_id,omitempty"`
+}
+
+// ActionResult is the server's reply to a power request. If the action needs
+// confirmation, Status is "confirm" and Token must be passed to Confirm
+// before ExpiresAt; otherwise JobID identifies the scheduled job.
+type ActionResult struct {
+	Status    string    `json:"status"`
+	Action    string    `json:"action,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	JobID     string    `json:"job_id,omitempty"`
+	Token     string    `json:"token,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// NeedsConfirmation reports whether the action waits for Confirm.
+func (r *ActionResult) NeedsConfirmation() bool { return r.Status == "confirm" }
+
+// Stats is the server's CPU, memory, uptime and load.
+type Stats struct {
+	CPUUsage      float64   `json:"cpu_usage_percent"`
+	MemoryTotal   uint64    `json:"memory_total_bytes"`
+	MemoryFree    uint64    `json:"memory_free_bytes"`
+	MemoryUsed    uint64    `json:"memory_used_bytes"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	LoadAverage   []float64 `json:"load_average,omitempty"` // 1, 5 and 15 minutes; Linux only
+}
+
+// CertInfo is the expiry summary of a certificate the server uses or has
+// seen.
+type CertInfo struct {
+	Kind        string     `json:"kind"` // server, ca or client
+	CN          string     `json:"cn"`
+	Fingerprint string     `json:"fingerprint"`
+	NotAfter    time.Time  `json:"not_after"`
+	DaysLeft    int        `json:"days_left"`
+	LastSeen    *time.Time `json:"last_seen,omitempty"` // client certs only
+}
+
+// Job is a power action the server has scheduled or run.
+type Job struct {
+	ID                   string            `json:"id"`
+	Action               string            `json:"action"`
+	Params               map[string]string `json:"params,omitempty"`
+	Requester            string            `json:"requester,omitempty"`
+	RequesterFingerprint string            `json:"requester_fingerprint,omitempty"`
+	RequesterAddr        string            `json:"requester_addr,omitempty"`
+	RequestID            string            `json:"request_id,omitempty"`
+	DryRun               bool              `json:"dry_run,omitempty"`
+	State                string            `json:"state"` // pending, running, succeeded, failed or cancelled
+	Error                string            `json:"error,omitempty"`
+	CreatedAt            time.Time         `json:"created_at"`
+	RunAt                time.Time         `json:"run_at"`
+	StartedAt            *time.Time        `json:"started_at,omitempty"`
+	FinishedAt           *time.Time        `json:"finished_at,omitempty"`
+}
+
+// Ban is a source IP the server refuses connections from after repeated
+// failed handshakes, unauthorized requests or allowlist hits.
+type Ban struct {
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason"` // handshake, unauthorized or allowlist
+	Failures  int       `json:"failures"`
+	BannedAt  time.Time `json:"banned_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ActionList is the set of actions a server supports.
+type ActionList struct {
+	Backend string       `json:"backend"`
+	Actions []ActionInfo `json:"actions"`
+}
+
+// ActionInfo names an action and the ActionOptions it accepts, by their JSON
+// names ("delay", "force", "message", "reason").
+type ActionInfo struct {
+	Name   string   `json:"name"`
+	Params []string `json:"params"`
+}
+
+// ActionOptions modify a power request. The server rejects options the
+// action doesn't support; see Actions.
+type ActionOptions struct {
+	Delay   time.Duration // run after this long, rounded up to whole seconds
+	At      time.Time     // run at this time instead of after Delay
+	Force   bool          // don't wait for applications, and override the server's inhibitors
+	Message string        // shown to logged-in users beforehand; only logged on Windows
+	Reason  string        // recorded in the server's audit log
+}
+
+type actionBody struct {
+	Action  string `json:"action"`
+	Delay   any    `json:"delay,omitempty"` // seconds or an RFC3339 time; nil, not 0, to omit it
+	Force   bool   `json:"force,omitempty"`
+	Message string `json:"message,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Health checks that the server is up.
+func (c *Client) Health(ctx context.Context) (*Response, error) {
+	var r Response
+	return &r, c.do(ctx, http.MethodGet, "/health", nil, &r)
+}
+
+// Stats returns the server's system stats.
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	var s Stats
+	return &s, c.do(ctx, http.MethodGet, "/stats", nil, &s)
+}
+
+// Certs returns the expiry of the server, CA and client certificates.
+func (c *Client) Certs(ctx context.Context) ([]CertInfo, error) {
+	var certs []CertInfo
+	err := c.do(ctx, http.MethodGet, "/certs", nil, &certs)
+	return certs, err
+}
+
+// Metrics returns the server's Prometheus metrics in the text format.
+func (c *Client) Metrics(ctx context.Context) (string, error) {
+	var s string
+	err := c.do(ctx, http.MethodGet, "/metrics", nil, &s)
+	return s, err
+}
+
+// OpenAPI returns the server's OpenAPI document.
+func (c *Client) OpenAPI(ctx context.Context) (json.RawMessage, error) {
+	var doc json.RawMessage
+	err := c.do(ctx, http.MethodGet, "/openapi.json", nil, &doc)
+	return doc, err
+}
+
+// Actions lists the actions the server supports and their options.
+func (c *Client) Actions(ctx context.Context) (*ActionList, error) {
+	var l ActionList
+	return &l, c.do(ctx, http.MethodGet, "/v1/actions", nil, &l)
+}
+
+// Do requests action. The returned result needs Confirm if the server asks
+// for confirmation.
+func (c *Client) Do(ctx context.Context, action string, opts ActionOptions) (*ActionResult, error) {
+	body := actionBody{Action: action, Force: opts.Force, Message: opts.Message, Reason: opts.Reason}
+	switch {
+	case !opts.At.IsZero():
+		body.Delay = opts.At.Format(time.RFC3339)
+	case opts.Delay > 0:
+		// Round up, so that a delay under a second isn't sent as none
+		body.Delay = int64((opts.Delay + time.Second - 1) / time.Second)
+	case opts.Delay < 0:
+		return nil, errors.New("delay must not be negative")
+	}
+	var r ActionResult
+	return &r, c.do(ctx, http.MethodPost, "/v1/actions", body, &r)
+}
+
+// Confirm runs an action that Do returned a confirmation token for.
+func (c *Client) Confirm(ctx context.Context, token string) (*ActionResult, error) {
+	var r ActionResult
+	return &r, c.do(ctx, http.MethodPost, "/confirm/"+url.PathEscape(token), nil, &r)
+}
+
+// Cancel cancels the pending job id, or every pending job if id is empty.
+func (c *Client) Cancel(ctx context.Context, id string) (*Response, error) {
+	path := "/cancel"
+	if id != "" {
+		path += "?" + url.Values{"id": {id}}.Encode()
+	}
+	var r Response
+	return &r, c.do(ctx, http.MethodPost, path, nil, &r)
+}
+
+// Jobs lists recent jobs, newest first.
+func (c *Client) Jobs(ctx context.Context) ([]Job, error) {
+	var jobs []Job
+	err := c.do(ctx, http.MethodGet, "/jobs", nil, &jobs)
+	return jobs, err
+}
+
+// Job returns the job with the given ID.
+func (c *Client) Job(ctx context.Context, id string) (*Job, error) {
+	var j Job
+	return &j, c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), nil, &j)
+}
+
+// Wake asks the server to send a Wake-on-LAN packet to one of its configured
+// peers.
+func (c *Client) Wake(ctx context.Context, peer string) (*Response, error) {
+	var r Response
+	return &r, c.do(ctx, http.MethodPost, "/wake?"+url.Values{"peer": {peer}}.Encode(), nil, &r)
+}
+
+// Bans lists the IPs the server has banned, soonest to expire first. The
+// server only serves it if banning is enabled.
+func (c *Client) Bans(ctx context.Context) ([]Ban, error) {
+	var bans []Ban
+	err := c.do(ctx, http.MethodGet, "/bans", nil, &bans)
+	return bans, err
+}
+
+// Unban lifts the server's ban on ip, or every ban if ip is empty.
+func (c *Client) Unban(ctx context.Context, ip string) (*Response, error) {
+	path := "/bans"
+	if ip != "" {
+		path += "/" + url.PathEscape(ip)
+	}
+	var r Response
+	return &r, c.do(ctx, http.MethodDelete, path, nil, &r)
+}
+
+// WaitUp polls /health, backing off from 0.5s to 5s between attempts, until
+// the server answers. It returns ctx's error if ctx is done first.
+func (c *Client) WaitUp(ctx context.Context) error {
+	return c.wait(ctx, true)
+}
+
+// WaitDown polls /health like WaitUp until the server stops answering.
+func (c *Client) WaitDown(ctx context.Context) error {
+	return c.wait(ctx, false)
+}
+
+func (c *Client) wait(ctx context.Context, up bool) error {
+	const (
+		minInterval = 500 * time.Millisecond
+		maxInterval = 5 * time.Second
+	)
+	interval := minInterval
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, maxInterval)
+		err := c.send(attemptCtx, http.MethodGet, "/health", nil, nil)
+		cancel()
+		if (err == nil) == up {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		interval = min(interval*3/2, maxInterval)
+	}
+}
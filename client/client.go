@@ -0,0 +1,288 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package client is a Go client for the winshut API.
+//
+//	c, err := client.New(client.Config{
+//		Server: "https://mypc.local:9090",
+//		CA:     "certs/ca.crt",
+//		Cert:   "certs/client.crt",
+//		Key:    "certs/client.key",
+//	})
+//	res, err := c.Do(ctx, "restart", client.ActionOptions{Delay: 10 * time.Minute})
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRetries is how many times a failed request is retried unless
+	// WithRetries says otherwise.
+	DefaultRetries = 2
+	// DefaultBackoff is the wait before the first retry; it doubles with
+	// each one.
+	DefaultBackoff = 500 * time.Millisecond
+)
+
+// maxResponseSize bounds how much of a response body is read.
+const maxResponseSize = 4 << 20
+
+// Config holds the settings for one server, with the same keys as the CLI
+// client's config file.
+type Config struct {
+	Server string `yaml:"server"` // base URL, e.g. https://mypc.local:9090
+	CA     string `yaml:"ca"`     // CA for verifying the server; system roots if empty
+	Cert   string `yaml:"cert"`   // client certificate for mTLS
+	Key    string `yaml:"key"`
+}
+
+// Client talks to one winshut server. It is safe for concurrent use.
+type Client struct {
+	server       string
+	http         *http.Client
+	cert         *x509.Certificate
+	retries      int
+	backoff      time.Duration
+	onServerCert func(*x509.Certificate)
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient makes the Client send requests with hc, which must present
+// a client certificate, instead of one built from the Config's files.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithRetries sets how many times a failed request is retried. Rate-limited
+// requests are always retried, since the server rejected them before doing
+// anything; other failures only for requests that don't change anything.
+func WithRetries(n int) Option {
+	return func(c *Client) { c.retries = max(n, 0) }
+}
+
+// WithBackoff sets the wait before the first retry. A Retry-After header
+// from the server takes precedence.
+func WithBackoff(d time.Duration) Option {
+	return func(c *Client) { c.backoff = d }
+}
+
+// WithServerCertHook calls fn with the server's certificate on every
+// response, e.g. to warn about its expiry.
+func WithServerCertHook(fn func(*x509.Certificate)) Option {
+	return func(c *Client) { c.onServerCert = fn }
+}
+
+// New returns a Client for the server in cfg. Unless WithHTTPClient is given,
+// cfg.Cert and cfg.Key are required.
+func New(cfg Config, opts ...Option) (*Client, error) {
+	if cfg.Server == "" {
+		return nil, errors.New("'server' is required")
+	}
+	c := &Client{
+		server:  strings.TrimRight(cfg.Server, "/"),
+		retries: DefaultRetries,
+		backoff: DefaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.http != nil {
+		return c, nil
+	}
+
+	if cfg.Cert == "" || cfg.Key == "" {
+		return nil, errors.New("'cert' and 'key' are required for mTLS")
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS13,
+	}
+	if cfg.CA != "" {
+		caCert, err := os.ReadFile(cfg.CA)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("failed to parse CA certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load client cert/key: %w", err)
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+	c.cert = cert.Leaf
+	c.http = &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	return c, nil
+}
+
+// Server returns the server's base URL.
+func (c *Client) Server() string { return c.server }
+
+// Certificate returns the client certificate loaded from the Config, or nil
+// if the Client was given its own HTTP client.
+func (c *Client) Certificate() *x509.Certificate { return c.cert }
+
+// do sends a request with body encoded as JSON, if not nil, and decodes the
+// response into out: a *string receives the body as is, anything else is
+// decoded from JSON. Failed requests are retried as WithRetries describes.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		err := c.send(ctx, method, path, data, out)
+		if err == nil || attempt >= c.retries || !retryable(method, err) || ctx.Err() != nil {
+			return err
+		}
+		wait := backoff
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			wait = apiErr.RetryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path string, data []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if c.onServerCert != nil && resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		c.onServerCert(resp.TLS.PeerCertificates[0])
+	}
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newAPIError(resp, respBody)
+	}
+	switch out := out.(type) {
+	case nil:
+		return nil
+	case *string:
+		*out = string(respBody)
+		return nil
+	default:
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return fmt.Errorf("decoding response: %w", err)
+		}
+		return nil
+	}
+}
+
+// retryable reports whether a request that failed with err may be sent
+// again. A power request that failed in transit may still have been carried
+// out, so only rate-limited ones are retried.
+func retryable(method string, err error) bool {
+	var apiErr *APIError
+	ok := errors.As(err, &apiErr)
+	if ok && apiErr.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if method != http.MethodGet {
+		return false
+	}
+	if !ok {
+		return true
+	}
+	switch apiErr.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Errors matched by an *APIError according to its status code.
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrInhibited    = errors.New("inhibited")
+)
+
+// APIError is returned when the server answers with a non-2xx status. Use
+// errors.Is with ErrUnauthorized, ErrForbidden, ErrRateLimited and
+// ErrInhibited to test for those statuses.
+type APIError struct {
+	StatusCode int
+	Message    string        // from the response, or the status text
+	RetryAfter time.Duration // from the Retry-After header, if any
+}
+
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	e := &APIError{StatusCode: resp.StatusCode}
+	var r Response
+	if json.Unmarshal(body, &r) == nil && r.Message != "" {
+		e.Message = r.Message
+	} else {
+		e.Message = strings.ToLower(http.StatusText(resp.StatusCode))
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return e
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrInhibited:
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testClient returns a Client for srv that retries without waiting long.
+func testClient(t *testing.T, srv *httptest.Server, opts ...Option) *Client {
+	t.Helper()
+	c, err := New(Config{Server: srv.URL}, append([]Option{WithHTTPClient(srv.Client()), WithBackoff(time.Millisecond)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDoBody(t *testing.T) {
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		w.Write([]byte(`{"status":"ok","job_id":"a1"}`))
+	}))
+	defer srv.Close()
+	c := testClient(t, srv)
+
+	at := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name string
+		opts ActionOptions
+		want string
+	}{
+		{"no delay", ActionOptions{}, `{"action":"restart"}`},
+		{"seconds", ActionOptions{Delay: 90 * time.Second}, `{"action":"restart","delay":90}`},
+		{"rounded up", ActionOptions{Delay: 1500 * time.Millisecond}, `{"action":"restart","delay":2}`},
+		{"under a second", ActionOptions{Delay: time.Millisecond}, `{"action":"restart","delay":1}`},
+		{"at", ActionOptions{At: at, Delay: time.Minute}, `{"action":"restart","delay":"2030-01-02T03:04:05Z"}`},
+		{"options", ActionOptions{Force: true, Message: "bye", Reason: "patching"}, `{"action":"restart","force":true,"message":"bye","reason":"patching"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := c.Do(context.Background(), "restart", tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body != tt.want {
+				t.Errorf("sent %s, want %s", body, tt.want)
+			}
+			if r.JobID != "a1" {
+				t.Errorf("got job %q, want a1", r.JobID)
+			}
+		})
+	}
+
+	if _, err := c.Do(context.Background(), "restart", ActionOptions{Delay: -time.Second}); err == nil {
+		t.Error("negative delay accepted")
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	tests := []struct {
+		status  int
+		body    string
+		is      error
+		message string
+	}{
+		{http.StatusUnauthorized, "", ErrUnauthorized, "unauthorized"},
+		{http.StatusForbidden, `{"status":"error","message":"role viewer may not restart"}`, ErrForbidden, "role viewer may not restart"},
+		{http.StatusTooManyRequests, `{"status":"error","message":"rate limit exceeded"}`, ErrRateLimited, "rate limit exceeded"},
+		{http.StatusConflict, `{"status":"error","message":"inhibited by backup.lock"}`, ErrInhibited, "inhibited by backup.lock"},
+		{http.StatusNotFound, "not json", nil, "not found"},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", "7")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			_, err := testClient(t, srv, WithRetries(0)).Health(context.Background())
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("got %v, want an *APIError", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Message != tt.message || apiErr.RetryAfter != 7*time.Second {
+				t.Errorf("got %+v", apiErr)
+			}
+			for _, target := range []error{ErrUnauthorized, ErrForbidden, ErrRateLimited, ErrInhibited} {
+				if got := errors.Is(err, target); got != (target == tt.is) {
+					t.Errorf("errors.Is(err, %v) = %v", target, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		status int
+		calls  int32
+	}{
+		{"read on 503", http.MethodGet, http.StatusServiceUnavailable, 3},
+		{"read on 500", http.MethodGet, http.StatusInternalServerError, 1},
+		{"power request on 503", http.MethodPost, http.StatusServiceUnavailable, 1},
+		{"power request on 429", http.MethodPost, http.StatusTooManyRequests, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+			c := testClient(t, srv)
+
+			var err error
+			if tt.method == http.MethodGet {
+				_, err = c.Stats(context.Background())
+			} else {
+				_, err = c.Do(context.Background(), "shutdown", ActionOptions{})
+			}
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if n := calls.Load(); n != tt.calls {
+				t.Errorf("got %d calls, want %d", n, tt.calls)
+			}
+		})
+	}
+}
+
+func TestRetrySucceeds(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer srv.Close()
+
+	r, err := testClient(t, srv).Health(context.Background())
+	if err != nil || r.Status != "ok" {
+		t.Fatalf("got %+v, %v; want status ok", r, err)
+	}
+}
+
+func TestWaitUp(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := testClient(t, srv).WaitUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("got %d calls, want 2", n)
+	}
+}
+
+func TestWaitDown(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	c := testClient(t, srv)
+
+	// Up, so WaitDown keeps polling until ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := c.WaitDown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the context's error", err)
+	}
+
+	srv.Close()
+	if err := c.WaitDown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,133 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/tomoconnor/winshut/client"
+)
+
+// hostConfig is one named server in the inventory. Empty TLS and broadcast
+// fields fall back to the top-level ones.
+type hostConfig struct {
+	client.Config `yaml:",inline"`
+
+	// Wake-on-LAN settings for the wake command
+	MAC       string `yaml:"mac"`
+	Broadcast string `yaml:"broadcast"`
+	SecureOn  string `yaml:"secureon"`
+	Relay     string `yaml:"relay"`      // host whose server sends the packet via POST /wake
+	RelayPeer string `yaml:"relay_peer"` // this host's name in the relay's wake.peers; the host name if empty
+}
+
+// target is a host selected for a command, with its TLS settings resolved.
+type target struct {
+	Name string
+	hostConfig
+}
+
+// appendList returns a flag.Func that adds each comma-separated value to
+// *list, so the flag may be repeated and/or given a list.
+func appendList(list *[]string) func(string) error {
+	return func(v string) error {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				*list = append(*list, s)
+			}
+		}
+		return nil
+	}
+}
+
+// targets resolves -H patterns and --group names against the inventory. With
+// neither, the top-level server is the only target.
+func (c *config) targets(patterns, groups []string) ([]target, error) {
+	if len(patterns) == 0 && len(groups) == 0 {
+		if c.Server == "" {
+			return nil, fmt.Errorf("'server' is required in config (or select hosts with -H or --group)")
+		}
+		return []target{c.resolve("", hostConfig{Config: client.Config{Server: c.Server}, MAC: c.MAC, SecureOn: c.SecureOn})}, nil
+	}
+
+	selected := make(map[string]bool)
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid host pattern %q: %w", pattern, err)
+		}
+		matched := false
+		for name := range c.Hosts {
+			if ok, _ := path.Match(pattern, name); ok {
+				selected[name] = true
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no hosts match %q", pattern)
+		}
+	}
+	for _, group := range groups {
+		members, ok := c.Groups[group]
+		if !ok {
+			return nil, fmt.Errorf("unknown group %q", group)
+		}
+		for _, name := range members {
+			selected[name] = true
+		}
+	}
+
+	var targets []target
+	for _, name := range slices.Sorted(maps.Keys(selected)) {
+		h, ok := c.Hosts[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown host %q", name)
+		}
+		if h.Server == "" {
+			return nil, fmt.Errorf("hosts.%s: 'server' is required", name)
+		}
+		targets = append(targets, c.resolve(name, h))
+	}
+	return targets, nil
+}
+
+func (c *config) resolve(name string, h hostConfig) target {
+	if h.CA == "" {
+		h.CA = c.CA
+	}
+	if h.Cert == "" {
+		h.Cert = c.Cert
+	}
+	if h.Key == "" {
+		h.Key = c.Key
+	}
+	if h.Broadcast == "" {
+		h.Broadcast = c.Broadcast
+	}
+	return target{Name: name, hostConfig: h}
+}
+
+// fanOut calls fn for each index in [0, n) using at most parallel goroutines.
+func fanOut(n, parallel int, fn func(i int)) {
+	if parallel < 1 {
+		parallel = 1
+	}
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,141 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const testConfig = `
+server: https://desk.local:9090
+ca: ca.crt
+cert: client.crt
+key: client.key
+broadcast: 192.168.1.255
+hosts:
+  render-01:
+    server: https://render-01.local:9090
+    mac: 00:1a:2b:3c:4d:01
+  render-02:
+    server: https://render-02.local:9090
+    cert: render.crt
+    key: render.key
+  nas:
+    server: https://nas.local:9090
+    broadcast: 10.0.0.255
+groups:
+  render: [render-01, render-02]
+  broken: [missing]
+`
+
+func TestTargets(t *testing.T) {
+	var cfg config
+	if err := yaml.Unmarshal([]byte(testConfig), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		groups   []string
+		want     []string // target names, or the error
+	}{
+		{"top-level server", nil, nil, []string{""}},
+		{"pattern", []string{"render-*"}, nil, []string{"render-01", "render-02"}},
+		{"group and host overlap", []string{"nas", "render-01"}, []string{"render"}, []string{"nas", "render-01", "render-02"}},
+		{"no match", []string{"web-*"}, nil, []string{`no hosts match "web-*"`}},
+		{"bad pattern", []string{"["}, nil, []string{`invalid host pattern "["`}},
+		{"unknown group", nil, []string{"web"}, []string{`unknown group "web"`}},
+		{"group with unknown host", nil, []string{"broken"}, []string{`unknown host "missing"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, err := cfg.targets(tt.patterns, tt.groups)
+			if err != nil {
+				if len(tt.want) != 1 || !strings.Contains(err.Error(), tt.want[0]) {
+					t.Fatalf("got error %v, want %v", err, tt.want)
+				}
+				return
+			}
+			var names []string
+			for _, target := range targets {
+				names = append(names, target.Name)
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("got %q, want %q", names, tt.want)
+			}
+		})
+	}
+}
+
+// Hosts inherit the top-level TLS and broadcast settings they don't set.
+func TestTargetsResolve(t *testing.T) {
+	var cfg config
+	if err := yaml.Unmarshal([]byte(testConfig), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	targets, err := cfg.targets([]string{"*"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]target)
+	for _, target := range targets {
+		got[target.Name] = target
+	}
+
+	if h := got["render-01"]; h.CA != "ca.crt" || h.Cert != "client.crt" || h.Key != "client.key" || h.Broadcast != "192.168.1.255" {
+		t.Errorf("render-01 did not inherit the top-level settings: %+v", h)
+	}
+	if h := got["render-02"]; h.Cert != "render.crt" || h.Key != "render.key" {
+		t.Errorf("render-02 lost its own cert: %+v", h)
+	}
+	if h := got["nas"]; h.Broadcast != "10.0.0.255" {
+		t.Errorf("nas lost its own broadcast address: %+v", h)
+	}
+}
+
+func TestAppendList(t *testing.T) {
+	var l []string
+	set := appendList(&l)
+	for _, v := range []string{"render-01, render-02", "nas", ",,"} {
+		if err := set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"render-01", "render-02", "nas"}; !slices.Equal(l, want) {
+		t.Errorf("got %q, want %q", l, want)
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	var (
+		mu            sync.Mutex
+		running, peak int
+		done          = make([]bool, 20)
+	)
+	fanOut(len(done), 3, func(i int) {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+
+		done[i] = true
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+	if peak > 3 {
+		t.Errorf("%d calls ran at once, want at most 3", peak)
+	}
+	if slices.Contains(done, false) {
+		t.Errorf("not every index was called: %v", done)
+	}
+}
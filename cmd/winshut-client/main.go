@@ -5,60 +5,109 @@
 package main
 
 import (
-	"crypto/tls"
+	"bufio"
+	"context"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
+	"github.com/tomoconnor/winshut/client"
+	"github.com/tomoconnor/winshut/wol"
 	"gopkg.in/yaml.v3"
 )
 
 type config struct {
-	Server string `yaml:"server"`
-	CA     string `yaml:"ca"`
-	Cert   string `yaml:"cert"`
-	Key    string `yaml:"key"`
+	client.Config `yaml:",inline"`
+
+	// Warn when the client or server cert expires within this long
+	ExpiryWarning time.Duration `yaml:"expiry_warning"`
+
+	// Wake-on-LAN settings for the top-level server. broadcast is also the
+	// default for hosts.
+	MAC       string `yaml:"mac"`
+	Broadcast string `yaml:"broadcast"`
+	SecureOn  string `yaml:"secureon"`
+
+	// Named servers and groups of them, selected with -H and --group
+	Hosts  map[string]hostConfig `yaml:"hosts"`
+	Groups map[string][]string   `yaml:"groups"`
 }
 
-var commands = map[string]struct {
-	method string
-	path   string
-}{
-	"health":     {http.MethodGet, "/health"},
-	"stats":      {http.MethodGet, "/stats"},
-	"shutdown":   {http.MethodPost, "/shutdown"},
-	"restart":    {http.MethodPost, "/restart"},
-	"hibernate":  {http.MethodPost, "/hibernate"},
-	"sleep":      {http.MethodPost, "/sleep"},
-	"lock":       {http.MethodPost, "/lock"},
-	"logoff":     {http.MethodPost, "/logoff"},
-	"screen-off": {http.MethodPost, "/screen-off"},
+const defaultExpiryWarning = 30 * 24 * time.Hour
+
+// commands are the commands that aren't power actions. Any other command is
+// sent to POST /v1/actions as the action name, so the client needs no update
+// when a server gains an action; "actions" lists what a server supports.
+var commands = []string{"health", "stats", "certs", "actions", "openapi", "cancel", "jobs", "bans", "unban"}
+
+// result is the outcome of a command on one host: the decoded response, to
+// be printed as JSON, or an error. Commands that make no request, like wake,
+// set note instead; --wait records how long the host took in note.
+type result struct {
+	value any
+	note  string
+	err   error
 }
 
 func main() {
 	configPath := flag.String("config", "winshut-client.yml", "path to config file")
+	delay := flag.String("delay", "", "delay shutdown/restart by seconds or until an RFC3339 time")
+	force := flag.Bool("force", false, "force the action even if applications or inhibitors would block it, where supported")
+	message := flag.String("message", "", "message shown to logged-in users before the action, where supported")
+	reason := flag.String("reason", "", "reason for the action, recorded in the server's audit log and webhooks")
+	yes := flag.Bool("yes", false, "confirm actions without prompting when the server asks for confirmation")
+	var hostPatterns, groups []string
+	flag.Func("H", "hosts from the config to run against, comma-separated or repeated; globs allowed", appendList(&hostPatterns))
+	flag.Func("group", "host groups from the config to run against, comma-separated or repeated", appendList(&groups))
+	parallel := flag.Int("parallel", 8, "maximum number of hosts to contact at once")
+	wait := flag.String("wait", "", "after the command, poll /health until the server is up or down")
+	timeout := flag.Duration("timeout", 5*time.Minute, "how long --wait polls before giving up")
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: winshut-client [--config path] <command>\n\nCommands: health, stats, shutdown, restart, hibernate, sleep, lock, logoff, screen-off\n\nFlags:\n")
+		fmt.Fprintf(os.Stderr, "Usage: winshut-client [--config path] [-H host,...] [--group name,...] [--delay secs|time] [--force] [--message text] [--reason text] [--yes] [--wait up|down [--timeout d]] <command>\n\nCommands: health, stats, certs, actions, openapi, cancel [job-id], jobs [job-id], bans, unban [ip], wake, or an action such as shutdown, restart, hibernate, sleep, lock, logoff, screen-off\n\nFlags:\n")
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 
-	if flag.NArg() != 1 {
+	// Flags may also follow the command, as in "restart --wait up"
+	var args []string
+	for flag.NArg() > 0 {
+		args = append(args, flag.Arg(0))
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+
+	if len(args) < 1 || len(args) > 2 || (len(args) == 2 && args[0] != "cancel" && args[0] != "jobs" && args[0] != "unban") {
 		flag.Usage()
 		os.Exit(1)
 	}
+	if *wait != "" && *wait != "up" && *wait != "down" {
+		fmt.Fprintf(os.Stderr, "error: --wait must be up or down, got %q\n", *wait)
+		os.Exit(1)
+	}
 
-	cmdName := flag.Arg(0)
-	cmd, ok := commands[cmdName]
-	if !ok {
-		fmt.Fprintf(os.Stderr, "error: unknown command %q\n", cmdName)
-		flag.Usage()
+	cmdName := args[0]
+	isAction := !slices.Contains(commands, cmdName) && cmdName != "wake"
+	if !isAction && (*delay != "" || *force || *message != "" || *reason != "") {
+		fmt.Fprintln(os.Stderr, "error: --delay, --force, --message and --reason only apply to power actions")
 		os.Exit(1)
 	}
+	opts := client.ActionOptions{Force: *force, Message: *message, Reason: *reason}
+	if *delay != "" {
+		if secs, err := strconv.Atoi(*delay); err == nil {
+			opts.Delay = time.Duration(secs) * time.Second
+		} else if opts.At, err = time.Parse(time.RFC3339, *delay); err != nil {
+			fmt.Fprintf(os.Stderr, "error: --delay must be a number of seconds or an RFC3339 time, got %q\n", *delay)
+			os.Exit(1)
+		}
+	}
 
 	// Load config
 	info, err := os.Stat(*configPath)
@@ -76,86 +125,266 @@ func main() {
 		os.Exit(1)
 	}
 
-	var cfg config
+	cfg := config{ExpiryWarning: defaultExpiryWarning}
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		fmt.Fprintf(os.Stderr, "error: invalid config: %v\n", err)
 		os.Exit(1)
 	}
 
-	if cfg.Server == "" {
-		fmt.Fprintln(os.Stderr, "error: 'server' is required in config")
+	targets, err := cfg.targets(hostPatterns, groups)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
+	multi := len(hostPatterns) > 0 || len(groups) > 0
+
+	// Build a client per host, since each may use its own CA and cert. wake
+	// only needs one to poll the host with --wait; a relay gets its own.
+	clients := make([]*client.Client, len(targets))
+	results := make([]result, len(targets))
+	warned := make(map[string]bool)
+	for i, t := range targets {
+		if cmdName == "wake" && *wait == "" {
+			continue
+		}
+		clients[i], results[i].err = newClient(t.Config, cfg.ExpiryWarning)
+		if clients[i] != nil && !warned[t.Cert] {
+			warned[t.Cert] = true
+			warnExpiry("client", clients[i].Certificate(), cfg.ExpiryWarning)
+		}
+	}
 
-	if cfg.Cert == "" || cfg.Key == "" {
-		fmt.Fprintln(os.Stderr, "error: 'cert' and 'key' are required in config for mTLS")
+	// Execute
+	ctx := context.Background()
+	fanOut(len(targets), *parallel, func(i int) {
+		c := clients[i]
+		switch {
+		case results[i].err != nil:
+		case cmdName == "wake":
+			results[i] = wake(ctx, targets[i], &cfg)
+		case cmdName == "health":
+			results[i].value, results[i].err = value(c.Health(ctx))
+		case cmdName == "stats":
+			results[i].value, results[i].err = value(c.Stats(ctx))
+		case cmdName == "certs":
+			results[i].value, results[i].err = value(c.Certs(ctx))
+		case cmdName == "actions":
+			results[i].value, results[i].err = value(c.Actions(ctx))
+		case cmdName == "openapi":
+			results[i].value, results[i].err = value(c.OpenAPI(ctx))
+		case cmdName == "cancel":
+			results[i].value, results[i].err = value(c.Cancel(ctx, arg(args, 1)))
+		case cmdName == "jobs" && len(args) == 2:
+			results[i].value, results[i].err = value(c.Job(ctx, args[1]))
+		case cmdName == "jobs":
+			results[i].value, results[i].err = value(c.Jobs(ctx))
+		case cmdName == "bans":
+			results[i].value, results[i].err = value(c.Bans(ctx))
+		case cmdName == "unban":
+			results[i].value, results[i].err = value(c.Unban(ctx, arg(args, 1)))
+		default:
+			results[i].value, results[i].err = value(c.Do(ctx, cmdName, opts))
+		}
+	})
+
+	// Servers may ask for a second request before running the action. Ask
+	// once for every host that did.
+	var pending []int
+	for i, res := range results {
+		if r, ok := res.value.(*client.ActionResult); ok && res.err == nil && r.NeedsConfirmation() {
+			fmt.Fprintf(os.Stderr, "Server %s %s\n", targets[i].Server, r.Message)
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) > 0 {
+		if *yes || prompt("Proceed? [y/N] ") {
+			fanOut(len(pending), *parallel, func(j int) {
+				i := pending[j]
+				token := results[i].value.(*client.ActionResult).Token
+				results[i].value, results[i].err = value(clients[i].Confirm(ctx, token))
+			})
+		} else {
+			for _, i := range pending {
+				results[i] = result{err: errors.New("aborted")}
+			}
+		}
+	}
+
+	// A restart has to be seen going down before coming back up counts
+	if *wait != "" {
+		fanOut(len(targets), *parallel, func(i int) {
+			if res := &results[i]; res.err == nil {
+				res.note, res.err = waitFor(clients[i], *wait, cmdName == "restart", *timeout)
+			}
+		})
+	}
+
+	failed := false
+	for _, res := range results {
+		failed = failed || res.err != nil
+	}
+	if multi {
+		printTable(targets, results)
+	} else {
+		res := results[0]
+		if res.err == nil && res.value != nil {
+			pretty, _ := json.MarshalIndent(res.value, "", "  ")
+			fmt.Println(string(pretty))
+		}
+		if res.err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", res.err)
+		} else if res.note != "" {
+			fmt.Println(res.note)
+		}
+	}
+	if failed {
 		os.Exit(1)
 	}
+}
+
+// value adapts a client method's results for storing in a result.
+func value[T any](v T, err error) (any, error) {
+	return v, err
+}
 
-	// Build TLS config
-	tlsConfig := &tls.Config{
-		MinVersion: tls.VersionTLS13,
+// arg returns args[i], or "" if there are not that many.
+func arg(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
 	}
+	return ""
+}
+
+// newClient builds a client for cfg that warns once if the server's cert is
+// about to expire.
+func newClient(cfg client.Config, expiryWarning time.Duration) (*client.Client, error) {
+	var once sync.Once
+	return client.New(cfg, client.WithServerCertHook(func(cert *x509.Certificate) {
+		once.Do(func() { warnExpiry("server", cert, expiryWarning) })
+	}))
+}
 
-	if cfg.CA != "" {
-		caCert, err := os.ReadFile(cfg.CA)
+// wake sends a magic packet for t, directly or through its relay server.
+func wake(ctx context.Context, t target, cfg *config) result {
+	if t.Relay != "" {
+		rh, ok := cfg.Hosts[t.Relay]
+		if !ok || rh.Server == "" {
+			return result{err: fmt.Errorf("relay %q is not a host with a server in the config", t.Relay)}
+		}
+		relay := cfg.resolve(t.Relay, rh)
+		rc, err := newClient(relay.Config, cfg.ExpiryWarning)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: cannot read CA file: %v\n", err)
-			os.Exit(1)
+			return result{err: err}
 		}
-		pool := x509.NewCertPool()
-		if !pool.AppendCertsFromPEM(caCert) {
-			fmt.Fprintln(os.Stderr, "error: failed to parse CA certificate")
-			os.Exit(1)
+		peer := t.RelayPeer
+		if peer == "" {
+			peer = t.Name
+		}
+		if _, err := rc.Wake(ctx, peer); err != nil {
+			return result{err: err}
 		}
-		tlsConfig.RootCAs = pool
+		return result{note: fmt.Sprintf("magic packet sent by %s", t.Relay)}
 	}
 
-	cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+	if t.MAC == "" {
+		return result{err: errors.New("no 'mac' configured for wake")}
+	}
+	mac, err := wol.ParseMAC(t.MAC)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: cannot load client cert/key: %v\n", err)
-		os.Exit(1)
+		return result{err: err}
 	}
-	tlsConfig.Certificates = []tls.Certificate{cert}
-
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: tlsConfig,
-		},
+	password, err := wol.ParsePassword(t.SecureOn)
+	if err != nil {
+		return result{err: err}
 	}
-
-	// Build request
-	url := cfg.Server + cmd.path
-	req, err := http.NewRequest(cmd.method, url, nil)
+	addr, err := wol.Send(mac, password, t.Broadcast, "")
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		return result{err: fmt.Errorf("sending magic packet: %w", err)}
 	}
+	return result{note: fmt.Sprintf("magic packet for %s sent to %s", mac, addr)}
+}
 
-	// Execute
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+// waitFor waits until c's server is in state ("up" or "down") or timeout
+// passes. With downFirst, waiting for up only starts once the server has been
+// seen down.
+func waitFor(c *client.Client, state string, downFirst bool, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	if state == "down" || downFirst {
+		if err := c.WaitDown(ctx); err != nil {
+			return "", fmt.Errorf("server not down after %s", timeout)
+		}
 	}
-	defer resp.Body.Close()
+	if state == "up" {
+		if err := c.WaitUp(ctx); err != nil {
+			return "", fmt.Errorf("server not up after %s", timeout)
+		}
+	}
+	return fmt.Sprintf("%s after %s", state, time.Since(start).Round(time.Second)), nil
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: reading response: %v\n", err)
-		os.Exit(1)
+// printTable prints one line per host: "ok" or the error status, and the
+// response message, or the whole response compacted if it has no message.
+func printTable(targets []target, results []result) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tSTATUS\tRESULT")
+	for i, res := range results {
+		status, text := "ok", ""
+		var apiErr *client.APIError
+		switch {
+		case errors.As(res.err, &apiErr):
+			status, text = strconv.Itoa(apiErr.StatusCode), apiErr.Message
+		case res.err != nil:
+			status, text = "error", res.err.Error()
+		default:
+			text = message(res.value)
+			if res.note != "" {
+				text = strings.TrimPrefix(text+"; "+res.note, "; ")
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", targets[i].Name, status, text)
 	}
+	tw.Flush()
+}
 
-	// Pretty-print JSON if valid, otherwise print raw
-	var parsed any
-	if err := json.Unmarshal(body, &parsed); err == nil {
-		pretty, _ := json.MarshalIndent(parsed, "", "  ")
-		fmt.Println(string(pretty))
-	} else {
-		fmt.Print(string(body))
+// message summarises a response for the table.
+func message(v any) string {
+	switch r := v.(type) {
+	case nil:
+		return ""
+	case *client.Response:
+		if r.Message != "" {
+			return r.Message
+		}
+	case *client.ActionResult:
+		if r.Message != "" {
+			return r.Message
+		}
 	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
 
-	if resp.StatusCode >= 300 {
-		os.Exit(1)
+// prompt asks a yes/no question on stderr and reads the answer from stdin.
+func prompt(question string) bool {
+	fmt.Fprint(os.Stderr, question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func warnExpiry(kind string, cert *x509.Certificate, within time.Duration) {
+	if cert == nil || within <= 0 {
+		return
+	}
+	left := time.Until(cert.NotAfter)
+	if left > within {
+		return
+	}
+	if left <= 0 {
+		fmt.Fprintf(os.Stderr, "warning: %s certificate %q expired on %s\n", kind, cert.Subject.CommonName, cert.NotAfter.Format(time.DateOnly))
+		return
 	}
+	fmt.Fprintf(os.Stderr, "warning: %s certificate %q expires in %d days (%s)\n", kind, cert.Subject.CommonName, int(left.Hours()/24), cert.NotAfter.Format(time.DateOnly))
 }
@@ -0,0 +1,139 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomoconnor/winshut/client"
+)
+
+// A direct wake sends the packet itself, so the host needs no cert or key.
+func TestWakeDirect(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	cfg := config{Hosts: map[string]hostConfig{
+		"render-01": {Config: client.Config{Server: "https://render-01:9090"}, MAC: "00:1a:2b:3c:4d:5e", Broadcast: conn.LocalAddr().String()},
+	}}
+	targets, err := cfg.targets([]string{"render-01"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := wake(context.Background(), targets[0], &cfg)
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 200)
+	if n, err := conn.Read(buf); err != nil || n != 102 {
+		t.Fatalf("read %d bytes, %v; want a 102-byte magic packet", n, err)
+	}
+}
+
+func TestWakeRelay(t *testing.T) {
+	var peer string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer = r.URL.Query().Get("peer")
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer srv.Close()
+	ca, cert, key := writeTestCerts(t, srv)
+
+	gateway := hostConfig{Config: client.Config{Server: srv.URL, CA: ca, Cert: cert, Key: key}}
+	tests := []struct {
+		relayPeer string
+		want      string
+	}{
+		{"", "render-02"},
+		{"r2", "r2"},
+	}
+	for _, tt := range tests {
+		cfg := config{Hosts: map[string]hostConfig{
+			"gateway":   gateway,
+			"render-02": {Config: client.Config{Server: "https://render-02:9090"}, Relay: "gateway", RelayPeer: tt.relayPeer},
+		}}
+		targets, err := cfg.targets([]string{"render-02"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res := wake(context.Background(), targets[0], &cfg); res.err != nil {
+			t.Fatal(res.err)
+		}
+		if peer != tt.want {
+			t.Errorf("relay_peer %q: relay asked to wake %q, want %q", tt.relayPeer, peer, tt.want)
+		}
+	}
+}
+
+// writeTestCerts writes srv's certificate as the CA and a self-signed client
+// keypair, which srv doesn't check, and returns their paths.
+func writeTestCerts(t *testing.T, srv *httptest.Server) (ca, cert, key string) {
+	t.Helper()
+	dir := t.TempDir()
+	ca, cert, key = filepath.Join(dir, "ca.crt"), filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, block := range map[string]*pem.Block{
+		ca:   {Type: "CERTIFICATE", Bytes: srv.Certificate().Raw},
+		cert: {Type: "CERTIFICATE", Bytes: der},
+		key:  {Type: "PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ca, cert, key
+}
+
+func TestWakeUnknownRelay(t *testing.T) {
+	cfg := config{Hosts: map[string]hostConfig{
+		"render-02": {Config: client.Config{Server: "https://render-02:9090"}, Relay: "gateway"},
+	}}
+	targets, err := cfg.targets([]string{"render-02"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := wake(context.Background(), targets[0], &cfg); res.err == nil || !strings.Contains(res.err.Error(), `relay "gateway"`) {
+		t.Fatalf("got %v, want an error about the relay", res.err)
+	}
+}
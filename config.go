@@ -0,0 +1,193 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type serverConfig struct {
+	Listen     []string          `yaml:"listen"`
+	TLS        tlsFileConfig     `yaml:"tls"`
+	Allow      []string          `yaml:"allow"`
+	RateLimit  rateLimitConfig   `yaml:"rate_limit"`
+	Ban        banConfig         `yaml:"ban"`
+	Actions    []string          `yaml:"actions"`
+	Inhibitors []inhibitorConfig `yaml:"inhibitors"`
+	Backend    string            `yaml:"backend"`
+	Authz      authzConfig       `yaml:"authz"`
+	Revocation revocationConfig  `yaml:"revocation"`
+	Log        logConfig         `yaml:"log"`
+	Audit      auditConfig       `yaml:"audit"`
+	Confirm    confirmConfig     `yaml:"confirm"`
+	Wake       wakeConfig        `yaml:"wake"`
+	Webhooks   []webhookConfig   `yaml:"webhooks"`
+	DryRun     bool              `yaml:"dry_run"`
+}
+
+type tlsFileConfig struct {
+	CertFile string `yaml:"cert"`
+	KeyFile  string `yaml:"key"`
+	CAFile   string `yaml:"ca"`
+
+	// How often to check the files above for changes; 0 disables polling
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+
+	// Log a warning when any cert comes within each of these of its expiry
+	ExpiryWarnings []time.Duration `yaml:"expiry_warnings"`
+}
+
+func defaultServerConfig() serverConfig {
+	return serverConfig{
+		Listen: []string{"127.0.0.1:9090"},
+		TLS: tlsFileConfig{
+			ReloadInterval: 30 * time.Second,
+			ExpiryWarnings: []time.Duration{30 * 24 * time.Hour, 7 * 24 * time.Hour, 24 * time.Hour},
+		},
+		Revocation: revocationConfig{ReloadInterval: 5 * time.Minute},
+		Confirm:    confirmConfig{TTL: 30 * time.Second},
+		Log:        logConfig{Format: "text", Level: "info", MaxSizeMB: 10, MaxBackups: 5},
+		RateLimit:  rateLimitConfig{Rate: 0.5, Burst: 2, ReadRate: 5, ReadBurst: 20, Key: []string{"identity"}, IdleTimeout: 10 * time.Minute},
+		Ban:        banConfig{Window: 10 * time.Minute, Duration: time.Hour},
+		Actions:    slices.Clone(powerActions),
+	}
+}
+
+// loadServerConfig reads a YAML config file on top of the defaults. Unknown
+// keys are rejected so that typos don't silently fall back to defaults.
+func loadServerConfig(path string) (serverConfig, error) {
+	cfg := defaultServerConfig()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, fmt.Errorf("cannot read config %s: %w", path, err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return cfg, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// validate checks every field and returns all problems at once.
+func (c *serverConfig) validate() error {
+	var errs []error
+
+	if len(c.Listen) == 0 {
+		errs = append(errs, errors.New("listen: at least one address is required"))
+	}
+	for i, addr := range c.Listen {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("listen[%d]: invalid address %q: %w", i, addr, err))
+		}
+	}
+
+	for _, f := range []struct{ name, path string }{
+		{"tls.cert", c.TLS.CertFile},
+		{"tls.key", c.TLS.KeyFile},
+		{"tls.ca", c.TLS.CAFile},
+	} {
+		if f.path == "" {
+			errs = append(errs, fmt.Errorf("%s: required", f.name))
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.name, err))
+		}
+	}
+
+	if c.TLS.ReloadInterval < 0 {
+		errs = append(errs, fmt.Errorf("tls.reload_interval: must not be negative, got %s", c.TLS.ReloadInterval))
+	}
+
+	for i, d := range c.TLS.ExpiryWarnings {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("tls.expiry_warnings[%d]: must be positive, got %s", i, d))
+		}
+	}
+
+	for i, s := range c.Allow {
+		if _, _, err := net.ParseCIDR(strings.TrimSpace(s)); err != nil {
+			errs = append(errs, fmt.Errorf("allow[%d]: invalid CIDR %q", i, s))
+		}
+	}
+
+	if err := c.RateLimit.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Ban.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	for i, action := range c.Actions {
+		if !slices.Contains(powerActions, action) {
+			errs = append(errs, fmt.Errorf("actions[%d]: unknown action %q (valid: %s)", i, action, strings.Join(powerActions, ", ")))
+		}
+	}
+
+	if err := validateInhibitors(c.Inhibitors); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, ok := powerBackends[c.Backend]; !ok && c.Backend != "" && c.Backend != "auto" {
+		errs = append(errs, fmt.Errorf("backend: %q is not available on this platform (valid: auto, %s)", c.Backend, powerBackendNames()))
+	}
+
+	if err := c.Authz.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Revocation.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Log.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Confirm.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Wake.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateWebhooks(c.Webhooks); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	var cidrs []*net.IPNet
+	for _, s := range list {
+		_, cidr, err := net.ParseCIDR(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", strings.TrimSpace(s), err)
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,161 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+type confirmConfig struct {
+	Actions []string      `yaml:"actions"` // actions that need a second request to run
+	TTL     time.Duration `yaml:"ttl"`     // how long a confirmation token stays valid
+}
+
+func (c *confirmConfig) validate() error {
+	var errs []error
+	for i, action := range c.Actions {
+		if !slices.Contains(powerActions, action) {
+			errs = append(errs, fmt.Errorf("confirm.actions[%d]: unknown action %q", i, action))
+		}
+	}
+	if c.TTL <= 0 {
+		errs = append(errs, fmt.Errorf("confirm.ttl: must be positive, got %s", c.TTL))
+	}
+	return errors.Join(errs...)
+}
+
+// confirmResponse is returned instead of running an action that needs
+// confirmation.
+type confirmResponse struct {
+	Status    string    `json:"status"`
+	Action    string    `json:"action"`
+	Message   string    `json:"message"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type pendingConfirmation struct {
+	req         actionRequest
+	fingerprint string
+	expires     time.Time
+}
+
+// confirmer holds single-use tokens for actions awaiting confirmation. A nil
+// confirmer requires no confirmation for anything and has no handler, so
+// /confirm/{token} is only registered when it is non-nil.
+type confirmer struct {
+	actions  []string
+	ttl      time.Duration
+	hostname string
+
+	mu      sync.Mutex
+	pending map[string]pendingConfirmation
+}
+
+func newConfirmer(cfg confirmConfig) *confirmer {
+	if len(cfg.Actions) == 0 {
+		return nil
+	}
+	hostname, _ := os.Hostname()
+	return &confirmer{
+		actions:  cfg.Actions,
+		ttl:      cfg.TTL,
+		hostname: hostname,
+		pending:  make(map[string]pendingConfirmation),
+	}
+}
+
+func (c *confirmer) required(action string) bool {
+	return c != nil && slices.Contains(c.actions, action)
+}
+
+// issue stores req under a new token and tells the client what confirming
+// it will do.
+func (c *confirmer) issue(w http.ResponseWriter, r *http.Request, req actionRequest, dryRun bool) {
+	token := newConfirmToken()
+	now := time.Now()
+	by := requesterFromRequest(r)
+
+	c.mu.Lock()
+	for t, p := range c.pending {
+		if now.After(p.expires) {
+			delete(c.pending, t)
+		}
+	}
+	c.pending[token] = pendingConfirmation{req: req, fingerprint: by.Fingerprint, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+
+	summary := fmt.Sprintf("%s %q", req.Action, c.hostname)
+	if req.Delay > 0 {
+		summary += " at " + now.Add(req.Delay).Format(time.RFC3339)
+	} else {
+		summary += " immediately"
+	}
+	if dryRun {
+		summary += " (dry-run)"
+	}
+	slog.InfoContext(r.Context(), "confirmation required", "action", req.Action, "cn", by.CN)
+
+	writeJSON(w, http.StatusAccepted, confirmResponse{
+		Status:    "confirm",
+		Action:    req.Action,
+		Message:   fmt.Sprintf("will %s; confirm with POST /confirm/{token} within %s", summary, c.ttl),
+		Token:     token,
+		ExpiresAt: now.Add(c.ttl),
+	})
+}
+
+// handler serves POST /confirm/{token}, running the action stored under the
+// token if it was issued to the same client cert and has not expired.
+func (c *confirmer) handler(jobs *jobManager, dryRun bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, response{Status: "error", Message: "method not allowed"})
+			return
+		}
+
+		token := r.PathValue("token")
+		by := requesterFromRequest(r)
+
+		c.mu.Lock()
+		p, ok := c.pending[token]
+		if ok && p.fingerprint == by.Fingerprint {
+			delete(c.pending, token)
+		}
+		c.mu.Unlock()
+
+		if !ok || p.fingerprint != by.Fingerprint || time.Now().After(p.expires) {
+			writeJSON(w, http.StatusNotFound, response{Status: "error", Message: "unknown or expired confirmation token"})
+			return
+		}
+
+		// Re-validate so that a relative delay counts from confirmation
+		req, err := newActionRequest(jobs, p.req.Action, p.req.Params, time.Now())
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, response{Status: "error", Message: err.Error()})
+			return
+		}
+		// An inhibitor may have become active while the client was confirming
+		if inhibited(w, r, jobs, req) {
+			return
+		}
+		startAction(w, r, jobs, req, dryRun)
+	}
+}
+
+func newConfirmToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
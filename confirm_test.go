@@ -0,0 +1,26 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// Without any actions needing confirmation there is no confirmer, and
+// /confirm/{token} must not reach a handler on a nil receiver.
+func TestConfirmWithoutConfirmer(t *testing.T) {
+	dir := testPKI(t, "client")
+	url := startTestServer(t, testServerConfig(dir))
+
+	resp, err := testClient(t, dir, "client").Post(url+"/confirm/0123456789abcdef", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
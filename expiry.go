@@ -0,0 +1,158 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// certInfo is the expiry summary of one certificate served by /certs.
+type certInfo struct {
+	Kind        string     `json:"kind"` // server, ca or client
+	CN          string     `json:"cn"`
+	Fingerprint string     `json:"fingerprint"`
+	NotAfter    time.Time  `json:"not_after"`
+	DaysLeft    int        `json:"days_left"`
+	LastSeen    *time.Time `json:"last_seen,omitempty"` // client certs only
+}
+
+// certMonitor tracks the expiry of the server cert, the CA and every client
+// cert that has connected, and logs a warning as each crosses a threshold.
+type certMonitor struct {
+	reloader   *certReloader
+	thresholds []time.Duration // ascending
+
+	mu      sync.Mutex
+	clients map[string]certInfo      // by fingerprint
+	warned  map[string]time.Duration // fingerprint -> smallest threshold warned about
+}
+
+func newCertMonitor(reloader *certReloader, thresholds []time.Duration) *certMonitor {
+	return &certMonitor{
+		reloader:   reloader,
+		thresholds: slices.Sorted(slices.Values(thresholds)),
+		clients:    make(map[string]certInfo),
+		warned:     make(map[string]time.Duration),
+	}
+}
+
+// middleware records the verified client cert of every request.
+func (m *certMonitor) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			m.observe(r.TLS.VerifiedChains[0][0])
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *certMonitor) observe(cert *x509.Certificate) {
+	info := newCertInfo("client", cert)
+	now := time.Now()
+	info.LastSeen = &now
+
+	m.mu.Lock()
+	_, known := m.clients[info.Fingerprint]
+	m.clients[info.Fingerprint] = info
+	m.mu.Unlock()
+
+	if !known {
+		m.check(info)
+	}
+}
+
+// certs returns the current server, CA and client cert summaries.
+func (m *certMonitor) certs() []certInfo {
+	server, cas := m.reloader.current()
+	infos := []certInfo{newCertInfo("server", server)}
+	for _, ca := range cas {
+		infos = append(infos, newCertInfo("ca", ca))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, fp := range slices.Sorted(maps.Keys(m.clients)) {
+		info := m.clients[fp]
+		info.DaysLeft = daysLeft(info.NotAfter)
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// watch checks every known cert against the thresholds now and then hourly.
+func (m *certMonitor) watch() {
+	for {
+		for _, info := range m.certs() {
+			m.check(info)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// check logs a warning the first time a cert comes within each threshold of
+// its expiry.
+func (m *certMonitor) check(info certInfo) {
+	left := time.Until(info.NotAfter)
+	if left <= 0 {
+		m.warnOnce(info, 0, "certificate expired")
+		return
+	}
+	for _, t := range m.thresholds {
+		if left <= t {
+			m.warnOnce(info, t, "certificate expires soon")
+			return
+		}
+	}
+}
+
+func (m *certMonitor) warnOnce(info certInfo, threshold time.Duration, msg string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if prev, ok := m.warned[info.Fingerprint]; ok && prev <= threshold {
+		return
+	}
+	m.warned[info.Fingerprint] = threshold
+	slog.Warn(msg, "kind", info.Kind, "cn", info.CN, "fingerprint", info.Fingerprint[:16], "days_left", daysLeft(info.NotAfter), "expires", info.NotAfter.Format(time.RFC3339))
+}
+
+func (m *certMonitor) handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, response{Status: "error", Message: "method not allowed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, m.certs())
+}
+
+func (m *certMonitor) writeGauges(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "# HELP winshut_cert_expiry_timestamp_seconds Certificate expiry as a Unix timestamp.\n# TYPE winshut_cert_expiry_timestamp_seconds gauge\n")
+	for _, info := range m.certs() {
+		fmt.Fprintf(buf, "winshut_cert_expiry_timestamp_seconds%s %d\n", formatLabels("kind", info.Kind, "cn", info.CN, "fingerprint", info.Fingerprint), info.NotAfter.Unix())
+	}
+}
+
+func newCertInfo(kind string, cert *x509.Certificate) certInfo {
+	fp := sha256.Sum256(cert.Raw)
+	return certInfo{
+		Kind:        kind,
+		CN:          cert.Subject.CommonName,
+		Fingerprint: hex.EncodeToString(fp[:]),
+		NotAfter:    cert.NotAfter,
+		DaysLeft:    daysLeft(cert.NotAfter),
+	}
+}
+
+func daysLeft(t time.Time) int {
+	return int(time.Until(t).Hours() / 24)
+}
@@ -6,15 +6,33 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
 	"time"
 )
 
+// powerActions lists every action the server knows how to perform.
+var powerActions = []string{"shutdown", "restart", "hibernate", "sleep", "lock", "logoff", "screen-off"}
+
+// actionSummaries describe powerActions in the OpenAPI document.
+var actionSummaries = map[string]string{
+	"shutdown":   "Shut down",
+	"restart":    "Restart",
+	"hibernate":  "Hibernate",
+	"sleep":      "Sleep (suspend to RAM)",
+	"lock":       "Lock workstation",
+	"logoff":     "Log off current user",
+	"screen-off": "Turn off monitor(s)",
+}
+
 type response struct {
 	Status  string `json:"status"`
 	Action  string `json:"action,omitempty"`
 	Message string `json:"message,omitempty"`
+	JobID   string `json:"job_id,omitempty"`
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -25,35 +43,254 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, response{Status: "ok"})
 }
 
-func powerHandler(action string, dryRun bool) http.HandlerFunc {
+// delayableActions may be scheduled for later with the delay parameter.
+var delayableActions = []string{"shutdown", "restart"}
+
+// actionParams are the parameters a power request may carry. delay is only
+// accepted for delayableActions, message only where the backend supports it,
+// force where the backend supports it or an inhibitor applies, and reason,
+// which is just recorded, everywhere.
+var actionParams = []string{"delay", "force", "message", "reason"}
+
+// maxMessageLen is the longest message or reason accepted, which is the limit
+// of the Windows shutdown comment.
+const maxMessageLen = 512
+
+// actionRequest is a validated request to run a power action.
+type actionRequest struct {
+	Action  string
+	Params  map[string]string
+	Delay   time.Duration
+	Options ActionOptions
+}
+
+// newActionRequest validates params for action on the jobs' backend.
+// Relative delays are measured from now.
+func newActionRequest(jobs *jobManager, action string, params map[string]string, now time.Time) (actionRequest, error) {
+	req := actionRequest{Action: action, Params: params}
+	supported := jobs.actionParams(action)
+	for _, name := range actionParams {
+		if params[name] != "" && !slices.Contains(supported, name) {
+			return req, fmt.Errorf("%s is not supported for %s", name, action)
+		}
+	}
+
+	var err error
+	if v := params["delay"]; v != "" {
+		if req.Delay, err = parseDelay(v, now); err != nil {
+			return req, fmt.Errorf("invalid delay: %w", err)
+		}
+	}
+	if v := params["force"]; v != "" {
+		if req.Options.Force, err = strconv.ParseBool(v); err != nil {
+			return req, fmt.Errorf("invalid force: %q is not a boolean", v)
+		}
+	}
+	for _, name := range []string{"message", "reason"} {
+		if len(params[name]) > maxMessageLen {
+			return req, fmt.Errorf("%s is longer than %d bytes", name, maxMessageLen)
+		}
+	}
+	req.Options.Message = params["message"]
+	return req, nil
+}
+
+// actionParams lists the parameters action accepts, in the order of
+// actionParams.
+func (m *jobManager) actionParams(action string) []string {
+	var params []string
+	for _, name := range actionParams {
+		switch name {
+		case "delay":
+			if slices.Contains(delayableActions, action) {
+				params = append(params, name)
+			}
+		case "reason":
+			params = append(params, name)
+		case "force":
+			if slices.Contains(m.backend.Options(action), name) || m.inhibitors.covers(action) {
+				params = append(params, name)
+			}
+		default:
+			if slices.Contains(m.backend.Options(action), name) {
+				params = append(params, name)
+			}
+		}
+	}
+	return params
+}
+
+// powerHandler serves the legacy POST /<action> routes, which take the
+// action parameters from the query string or form body and are otherwise
+// equivalent to POST /v1/actions.
+func powerHandler(action string, jobs *jobManager, confirm *confirmer, authz *authzPolicy, dryRun bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			writeJSON(w, http.StatusMethodNotAllowed, response{Status: "error", Message: "method not allowed"})
 			return
 		}
 
+		var params map[string]string
+		for _, name := range actionParams {
+			if v := r.FormValue(name); v != "" {
+				if params == nil {
+					params = make(map[string]string)
+				}
+				params[name] = v
+			}
+		}
+		runAction(w, r, jobs, confirm, authz, action, params, dryRun)
+	}
+}
+
+// runAction validates a request for action and either starts it or, if it
+// needs confirmation, issues a confirmation token. Forcing an action needs
+// the force permission, since it overrides inhibitors.
+func runAction(w http.ResponseWriter, r *http.Request, jobs *jobManager, confirm *confirmer, authz *authzPolicy, action string, params map[string]string, dryRun bool) {
+	req, err := newActionRequest(jobs, action, params, time.Now())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, response{Status: "error", Message: err.Error()})
+		return
+	}
+	if req.Options.Force && !authz.check(w, r, "force") {
+		return
+	}
+	if inhibited(w, r, jobs, req) {
+		return
+	}
+
+	if confirm.required(action) {
+		confirm.issue(w, r, req, dryRun)
+		return
+	}
+	startAction(w, r, jobs, req, dryRun)
+}
+
+// inhibited checks req against the inhibitors, unless it is forced, and
+// records the refusal and writes a 409 response naming those that are active.
+func inhibited(w http.ResponseWriter, r *http.Request, jobs *jobManager, req actionRequest) bool {
+	if req.Options.Force {
+		return false
+	}
+	err := jobs.inhibitors.check(req.Action)
+	if err == nil {
+		return false
+	}
+	by := requesterFromRequest(r)
+	slog.InfoContext(r.Context(), "action inhibited", "action", req.Action, "cn", by.CN, "error", err)
+	jobs.refuse(req, by, err)
+	writeJSON(w, http.StatusConflict, response{Status: "error", Action: req.Action, Message: err.Error()})
+	return true
+}
+
+// startAction schedules req as a job and reports it to the client.
+func startAction(w http.ResponseWriter, r *http.Request, jobs *jobManager, req actionRequest, dryRun bool) {
+	j := jobs.schedule(req, requesterFromRequest(r))
+
+	msg := "executing"
+	if dryRun {
+		msg = "dry-run"
+	}
+	if req.Delay > 0 {
+		msg = "scheduled for " + j.RunAt.Format(time.RFC3339)
 		if dryRun {
-			log.Printf("[dry-run] would execute: %s", action)
-			writeJSON(w, http.StatusOK, response{Status: "ok", Action: action, Message: "dry-run"})
+			msg += " (dry-run)"
+		}
+	}
+
+	// Send response before executing power command
+	writeJSON(w, http.StatusOK, response{Status: "ok", Action: req.Action, Message: msg, JobID: j.ID})
+
+	// Flush the response
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// parseDelay accepts a number of seconds or an RFC3339 time in the future.
+func parseDelay(v string, now time.Time) (time.Duration, error) {
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, fmt.Errorf("must not be negative")
+		}
+		return time.Duration(secs) * time.Second, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return 0, fmt.Errorf("%q is neither seconds nor an RFC3339 time", v)
+	}
+	if !t.After(now) {
+		return 0, fmt.Errorf("%s is in the past", v)
+	}
+	return t.Sub(now), nil
+}
+
+// cancelHandler cancels the pending job given by the id parameter, or every
+// pending job if no id is given.
+func cancelHandler(jobs *jobManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, response{Status: "error", Message: "method not allowed"})
 			return
 		}
 
-		// Send response before executing power command
-		writeJSON(w, http.StatusOK, response{Status: "ok", Action: action, Message: "executing"})
+		if id := r.FormValue("id"); id != "" {
+			cancelJob(w, r, jobs, id)
+			return
+		}
+
+		cancelled := jobs.cancelAll(requesterFromRequest(r))
+		writeJSON(w, http.StatusOK, response{Status: "ok", Message: fmt.Sprintf("cancelled %d pending action(s)", len(cancelled))})
+	}
+}
 
-		// Flush the response
-		if f, ok := w.(http.Flusher); ok {
-			f.Flush()
+func jobsHandler(jobs *jobManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, response{Status: "error", Message: "method not allowed"})
+			return
 		}
+		writeJSON(w, http.StatusOK, jobs.list())
+	}
+}
 
-		// Execute power command after a delay so the HTTP response has time to reach the client
-		go func() {
-			time.Sleep(500 * time.Millisecond)
-			if err := execPowerCommand(action); err != nil {
-				log.Printf("failed to execute %s: %v", action, err)
-			}
-		}()
+func jobHandler(jobs *jobManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		j, ok := jobs.get(r.PathValue("id"))
+		if !ok {
+			writeJSON(w, http.StatusNotFound, response{Status: "error", Message: "no such job " + r.PathValue("id")})
+			return
+		}
+		writeJSON(w, http.StatusOK, j)
+	}
+}
+
+func cancelJobHandler(jobs *jobManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cancelJob(w, r, jobs, r.PathValue("id"))
+	}
+}
+
+// byMethod dispatches to a handler per HTTP method, so that routes sharing a
+// path can require different permissions.
+func byMethod(handlers map[string]http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, ok := handlers[r.Method]
+		if !ok {
+			writeJSON(w, http.StatusMethodNotAllowed, response{Status: "error", Message: "method not allowed"})
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+}
+
+func cancelJob(w http.ResponseWriter, r *http.Request, jobs *jobManager, id string) {
+	j, ok := jobs.cancel(id, requesterFromRequest(r))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, response{Status: "error", Message: "no pending job " + id})
+		return
 	}
+	writeJSON(w, http.StatusOK, response{Status: "ok", Action: j.Action, Message: "cancelled", JobID: j.ID})
 }
 
 func statsHandler(w http.ResponseWriter, r *http.Request) {
@@ -64,7 +301,7 @@ func statsHandler(w http.ResponseWriter, r *http.Request) {
 
 	stats, err := getSystemStats()
 	if err != nil {
-		log.Printf("failed to get system stats: %v", err)
+		slog.ErrorContext(r.Context(), "failed to get system stats", "error", err)
 		writeJSON(w, http.StatusInternalServerError, response{Status: "error", Message: "failed to get stats"})
 		return
 	}
@@ -79,6 +316,6 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Cache-Control", "no-store")
 	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(v); err != nil {
-		log.Printf("failed to write response: %v", err)
+		slog.Warn("failed to write response", "error", err)
 	}
 }
@@ -0,0 +1,91 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testPKI issues a CA, a server cert for 127.0.0.1 and a client cert per name
+// into a temporary directory with the pki subcommands, and returns it.
+func testPKI(t *testing.T, clients ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := pkiInit([]string{"--dir", dir}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pkiServer([]string{"--dir", dir, "--san", "127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range clients {
+		if err := pkiClient([]string{"--dir", dir, "--name", name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// testServerConfig returns a config for a dry-run server on the stub backend
+// using the certs in dir.
+func testServerConfig(dir string) serverConfig {
+	cfg := defaultServerConfig()
+	cfg.Listen = []string{"127.0.0.1:0"}
+	cfg.TLS.CertFile = filepath.Join(dir, "server.crt")
+	cfg.TLS.KeyFile = filepath.Join(dir, "server.key")
+	cfg.TLS.CAFile = filepath.Join(dir, "ca.crt")
+	cfg.TLS.ReloadInterval = 0
+	cfg.Backend = "stub"
+	cfg.DryRun = true
+	return cfg
+}
+
+// startTestServer builds a server from cfg, serves it on a free port until
+// the test ends, and returns its base URL.
+func startTestServer(t *testing.T, cfg serverConfig) string {
+	t.Helper()
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	server, bans, err := buildServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeTLS(bans.listener(ln), "", "")
+	t.Cleanup(func() { server.Close() })
+	return "https://" + ln.Addr().String()
+}
+
+// testClient returns an HTTP client presenting the cert issued to name in
+// dir, which resumes TLS sessions where the server allows it.
+func testClient(t *testing.T, dir, name string) *http.Client {
+	t.Helper()
+	caPEM, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caPEM)
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:            pool,
+		Certificates:       []tls.Certificate{cert},
+		ClientSessionCache: tls.NewLRUClientSessionCache(8),
+	}}
+	t.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport}
+}
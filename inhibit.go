@@ -0,0 +1,162 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// defaultInhibitedActions are the actions an inhibitor applies to unless it
+// lists its own: those that end running programs.
+var defaultInhibitedActions = []string{"shutdown", "restart", "hibernate", "sleep", "logoff"}
+
+// inhibitorConfig blocks power actions while a condition holds. Exactly one
+// of Process, File, CPUAbove and Session must be set.
+type inhibitorConfig struct {
+	Name     string   `yaml:"name"`      // shown in the rejection; defaults to the condition
+	Actions  []string `yaml:"actions"`   // default: defaultInhibitedActions
+	Process  string   `yaml:"process"`   // a process with this executable name is running
+	File     string   `yaml:"file"`      // a file matching this glob exists, e.g. a lock file
+	CPUAbove float64  `yaml:"cpu_above"` // CPU usage is above this percentage
+	Session  bool     `yaml:"session"`   // a user is logged in
+}
+
+func validateInhibitors(inhibitors []inhibitorConfig) error {
+	var errs []error
+	for i, c := range inhibitors {
+		conditions := 0
+		for _, set := range []bool{c.Process != "", c.File != "", c.CPUAbove != 0, c.Session} {
+			if set {
+				conditions++
+			}
+		}
+		if conditions != 1 {
+			errs = append(errs, fmt.Errorf("inhibitors[%d]: exactly one of process, file, cpu_above or session is required", i))
+		}
+		if c.File != "" {
+			if _, err := filepath.Match(c.File, ""); err != nil {
+				errs = append(errs, fmt.Errorf("inhibitors[%d].file: invalid pattern %q", i, c.File))
+			}
+		}
+		if c.CPUAbove < 0 || c.CPUAbove >= 100 {
+			errs = append(errs, fmt.Errorf("inhibitors[%d].cpu_above: must be between 0 and 100, got %v", i, c.CPUAbove))
+		}
+		for j, action := range c.Actions {
+			if !slices.Contains(powerActions, action) {
+				errs = append(errs, fmt.Errorf("inhibitors[%d].actions[%d]: unknown action %q", i, j, action))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// condition describes what the inhibitor checks for, for its default name
+// and log messages.
+func (c *inhibitorConfig) condition() string {
+	switch {
+	case c.Process != "":
+		return "process " + c.Process + " running"
+	case c.File != "":
+		return c.File + " present"
+	case c.CPUAbove != 0:
+		return fmt.Sprintf("CPU above %v%%", c.CPUAbove)
+	default:
+		return "user logged in"
+	}
+}
+
+// active reports whether the inhibitor's condition holds.
+func (c *inhibitorConfig) active() (bool, error) {
+	switch {
+	case c.Process != "":
+		return processRunning(c.Process)
+	case c.File != "":
+		matches, err := filepath.Glob(c.File)
+		return len(matches) > 0, err
+	case c.CPUAbove != 0:
+		stats, err := getSystemStats()
+		if err != nil {
+			return false, err
+		}
+		return stats.CPUUsage > c.CPUAbove, nil
+	default:
+		n, err := activeSessions()
+		return n > 0, err
+	}
+}
+
+// inhibitors holds the configured inhibitors. A nil *inhibitors inhibits
+// nothing.
+type inhibitors struct {
+	list []inhibitorConfig
+}
+
+func newInhibitors(cfgs []inhibitorConfig) *inhibitors {
+	if len(cfgs) == 0 {
+		return nil
+	}
+	s := &inhibitors{}
+	for _, c := range cfgs {
+		if len(c.Actions) == 0 {
+			c.Actions = defaultInhibitedActions
+		}
+		if c.Name == "" {
+			c.Name = c.condition()
+		}
+		s.list = append(s.list, c)
+	}
+	return s
+}
+
+// covers reports whether any inhibitor applies to action, which makes force
+// meaningful for it.
+func (s *inhibitors) covers(action string) bool {
+	return s != nil && slices.ContainsFunc(s.list, func(c inhibitorConfig) bool {
+		return slices.Contains(c.Actions, action)
+	})
+}
+
+// inhibitedError lists the inhibitors that blocked an action.
+type inhibitedError struct {
+	reasons []string
+}
+
+func (e *inhibitedError) Error() string {
+	return "inhibited: " + strings.Join(e.reasons, ", ")
+}
+
+// check returns an *inhibitedError naming every inhibitor of action whose
+// condition holds. An inhibitor whose condition can't be checked counts as
+// holding, since a power action can't be taken back.
+func (s *inhibitors) check(action string) error {
+	if s == nil {
+		return nil
+	}
+	var reasons []string
+	for _, c := range s.list {
+		if !slices.Contains(c.Actions, action) {
+			continue
+		}
+		active, err := c.active()
+		if err != nil {
+			slog.Warn("cannot check inhibitor, treating it as active", "inhibitor", c.Name, "error", err)
+			reasons = append(reasons, fmt.Sprintf("%s (check failed: %v)", c.Name, err))
+			continue
+		}
+		if active {
+			reasons = append(reasons, c.Name)
+		}
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+	metrics.inc("winshut_inhibited_total", "action", action)
+	return &inhibitedError{reasons: reasons}
+}
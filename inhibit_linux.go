@@ -0,0 +1,82 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sessionsRoot is where systemd-logind keeps a file per login session.
+const sessionsRoot = "/run/systemd/sessions"
+
+func processRunning(name string) (bool, error) {
+	return findProcess(procRoot, name)
+}
+
+// findProcess reports whether a process under root is named name, by its
+// comm (which the kernel truncates to 15 bytes) or the base name of its
+// argv[0].
+func findProcess(root, name string) (bool, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || strings.TrimLeft(e.Name(), "0123456789") != "" {
+			continue
+		}
+		dir := filepath.Join(root, e.Name())
+		// The process may exit between listing and reading, so errors
+		// just mean no match
+		if comm, err := os.ReadFile(filepath.Join(dir, "comm")); err == nil && strings.TrimSpace(string(comm)) == name {
+			return true, nil
+		}
+		if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+			argv0, _, _ := bytes.Cut(cmdline, []byte{0})
+			if len(argv0) > 0 && filepath.Base(string(argv0)) == name {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func activeSessions() (int, error) {
+	return countSessions(sessionsRoot)
+}
+
+// countSessions counts the user sessions logind has recorded under root,
+// local or remote, that aren't closing.
+func countSessions(root string) (int, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range entries {
+		f, err := os.Open(filepath.Join(root, e.Name()))
+		if err != nil {
+			continue
+		}
+		fields := make(map[string]string)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if k, v, ok := strings.Cut(scanner.Text(), "="); ok {
+				fields[k] = v
+			}
+		}
+		f.Close()
+		if fields["CLASS"] == "user" && fields["STATE"] != "closing" {
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,19 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+//go:build !windows && !linux
+
+package main
+
+import "errors"
+
+// Without a way to check, process and session inhibitors always hold.
+
+func processRunning(string) (bool, error) {
+	return false, errors.ErrUnsupported
+}
+
+func activeSessions() (int, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,95 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Both a request refused by an inhibitor and a job blocked when it runs must
+// reach the audit log and webhooks as inhibited.
+func TestInhibitedOutcomeIsRecorded(t *testing.T) {
+	events := make(chan webhookEvent, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev webhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err == nil {
+			events <- ev
+		}
+	}))
+	defer hook.Close()
+
+	dir := testPKI(t, "client")
+	lock := filepath.Join(dir, "backup.lock")
+	cfg := testServerConfig(dir)
+	cfg.Audit.File = filepath.Join(dir, "audit.log")
+	cfg.Inhibitors = []inhibitorConfig{{File: lock}}
+	cfg.Webhooks = []webhookConfig{{URL: hook.URL, Events: []string{"inhibited"}}}
+	url := startTestServer(t, cfg)
+
+	c := testClient(t, dir, "client")
+	post := func(path string) int {
+		t.Helper()
+		resp, err := c.Post(url+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Accepted while nothing inhibits it, then blocked when it runs
+	if got := post("/shutdown"); got != http.StatusOK {
+		t.Fatalf("shutdown: got %d, want %d", got, http.StatusOK)
+	}
+	if err := os.WriteFile(lock, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := post("/restart"); got != http.StatusConflict {
+		t.Fatalf("restart: got %d, want %d", got, http.StatusConflict)
+	}
+
+	for range 2 {
+		select {
+		case ev := <-events:
+			if ev.Event != "inhibited" || !strings.HasPrefix(ev.Error, "inhibited: ") {
+				t.Errorf("got event %q with error %q", ev.Event, ev.Error)
+			}
+			// Only the blocked job has an ID; the refused request never became one
+			if hasJob := ev.JobID != ""; hasJob != (ev.Action == "shutdown") {
+				t.Errorf("%s: got job_id %q", ev.Action, ev.JobID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for inhibited webhooks")
+		}
+	}
+
+	// Webhooks are queued before the audit entry is written. Wait for the
+	// head file too, which is replaced after the entry, so that nothing is
+	// still writing to the directory when the test cleans it up.
+	want := map[string][]string{"shutdown": {"accepted", "inhibited"}, "restart": {"inhibited"}}
+	var got map[string][]string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		entries, warnings, err := verifyAuditLog(cfg.Audit.File)
+		if err != nil || len(warnings) > 0 {
+			continue
+		}
+		got = make(map[string][]string)
+		for _, e := range entries {
+			got[e.Action] = append(got[e.Action], e.Outcome)
+		}
+		if slices.Equal(got["shutdown"], want["shutdown"]) && slices.Equal(got["restart"], want["restart"]) {
+			return
+		}
+	}
+	t.Errorf("got audit outcomes %v, want %v", got, want)
+}
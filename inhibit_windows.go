@@ -0,0 +1,59 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// processRunning reports whether a process with the executable name name is
+// running, ignoring case and an .exe suffix.
+func processRunning(name string) (bool, error) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".exe")
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return false, err
+	}
+	defer windows.CloseHandle(snapshot)
+
+	entry := windows.ProcessEntry32{Size: uint32(unsafe.Sizeof(windows.ProcessEntry32{}))}
+	for err = windows.Process32First(snapshot, &entry); err == nil; err = windows.Process32Next(snapshot, &entry) {
+		exe := strings.ToLower(windows.UTF16ToString(entry.ExeFile[:]))
+		if strings.TrimSuffix(exe, ".exe") == name {
+			return true, nil
+		}
+	}
+	if errors.Is(err, windows.ERROR_NO_MORE_FILES) {
+		return false, nil
+	}
+	return false, err
+}
+
+// wtsActive is the WTS_CONNECTSTATE_CLASS of a session with a user logged on
+// and connected, at the console or over Remote Desktop.
+const wtsActive = 0
+
+func activeSessions() (int, error) {
+	var sessions *windows.WTS_SESSION_INFO
+	var count uint32
+	if err := windows.WTSEnumerateSessions(0, 0, 1, &sessions, &count); err != nil {
+		return 0, err
+	}
+	defer windows.WTSFreeMemory(uintptr(unsafe.Pointer(sessions)))
+
+	n := 0
+	for _, s := range unsafe.Slice(sessions, count) {
+		if s.State == wtsActive {
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,302 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// minJobDelay gives the HTTP response time to reach the client before a power
+// command takes the machine (and the connection) down.
+const minJobDelay = 500 * time.Millisecond
+
+// maxJobHistory bounds how many finished jobs are kept for GET /jobs.
+const maxJobHistory = 100
+
+type jobState string
+
+const (
+	jobPending   jobState = "pending"
+	jobRunning   jobState = "running"
+	jobSucceeded jobState = "succeeded"
+	jobFailed    jobState = "failed"
+	jobCancelled jobState = "cancelled"
+)
+
+func (jobState) openAPISchema() map[string]any {
+	return map[string]any{"type": "string", "enum": []jobState{jobPending, jobRunning, jobSucceeded, jobFailed, jobCancelled}}
+}
+
+// job records a power action from request to outcome.
+type job struct {
+	ID                   string            `json:"id"`
+	Action               string            `json:"action"`
+	Params               map[string]string `json:"params,omitempty"`
+	Requester            string            `json:"requester,omitempty"`
+	RequesterFingerprint string            `json:"requester_fingerprint,omitempty"`
+	RequesterAddr        string            `json:"requester_addr,omitempty"`
+	RequestID            string            `json:"request_id,omitempty"`
+	DryRun               bool              `json:"dry_run,omitempty"`
+	State                jobState          `json:"state"`
+	Error                string            `json:"error,omitempty"`
+	CreatedAt            time.Time         `json:"created_at"`
+	RunAt                time.Time         `json:"run_at"`
+	StartedAt            *time.Time        `json:"started_at,omitempty"`
+	FinishedAt           *time.Time        `json:"finished_at,omitempty"`
+
+	opts  ActionOptions
+	timer *time.Timer
+}
+
+// jobManager runs power actions on timers so that pending ones can be
+// cancelled, and keeps a bounded history of their outcomes.
+type jobManager struct {
+	backend    PowerBackend
+	inhibitors *inhibitors
+	audit      *auditLog
+	webhooks   *webhookNotifier
+	dryRun     bool
+
+	mu    sync.Mutex
+	jobs  map[string]*job
+	order []string // job IDs, oldest first
+}
+
+func newJobManager(backend PowerBackend, inhibitors *inhibitors, audit *auditLog, webhooks *webhookNotifier, dryRun bool) *jobManager {
+	return &jobManager{backend: backend, inhibitors: inhibitors, audit: audit, webhooks: webhooks, dryRun: dryRun, jobs: make(map[string]*job)}
+}
+
+func (m *jobManager) schedule(req actionRequest, by requester) job {
+	delay := max(req.Delay, minJobDelay)
+	now := time.Now()
+	j := &job{
+		ID:                   newJobID(),
+		Action:               req.Action,
+		Params:               req.Params,
+		Requester:            by.CN,
+		RequesterFingerprint: by.Fingerprint,
+		RequesterAddr:        by.RemoteAddr,
+		RequestID:            by.RequestID,
+		DryRun:               m.dryRun,
+		State:                jobPending,
+		CreatedAt:            now,
+		RunAt:                now.Add(delay),
+		opts:                 req.Options,
+	}
+
+	// Recorded before the job is visible, so that nothing can cancel or run
+	// it first
+	m.record(*j, by, "accepted")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[j.ID] = j
+	m.order = append(m.order, j.ID)
+	m.prune()
+	j.timer = time.AfterFunc(delay, func() { m.run(j) })
+	return *j
+}
+
+// record writes an audit entry and sends webhooks for a change to j made by
+// by. It syncs the audit log to disk, so callers pass a copy of the job
+// instead of holding m.mu.
+func (m *jobManager) record(j job, by requester, outcome string) {
+	event := outcome
+	if outcome == string(jobSucceeded) {
+		event = "executed"
+	}
+	m.webhooks.notify(webhookEvent{
+		Event:       event,
+		Action:      j.Action,
+		JobID:       j.ID,
+		Params:      j.Params,
+		DryRun:      j.DryRun,
+		CN:          by.CN,
+		Fingerprint: by.Fingerprint,
+		RemoteAddr:  by.RemoteAddr,
+		RequestID:   by.RequestID,
+		Error:       j.Error,
+	})
+
+	m.audit.record(auditEntry{
+		CN:          by.CN,
+		Fingerprint: by.Fingerprint,
+		RemoteAddr:  by.RemoteAddr,
+		RequestID:   by.RequestID,
+		Action:      j.Action,
+		Params:      j.Params,
+		DryRun:      j.DryRun,
+		JobID:       j.ID,
+		Outcome:     outcome,
+		Error:       j.Error,
+	})
+}
+
+func (j *job) requester() requester {
+	return requester{CN: j.Requester, Fingerprint: j.RequesterFingerprint, RemoteAddr: j.RequesterAddr, RequestID: j.RequestID}
+}
+
+func (m *jobManager) run(j *job) {
+	m.mu.Lock()
+	if j.State != jobPending {
+		// Cancelled after the timer fired but before we got the lock
+		m.mu.Unlock()
+		return
+	}
+	started := time.Now()
+	j.State = jobRunning
+	j.StartedAt = &started
+	m.mu.Unlock()
+
+	// Check again, since a delayed job may have been scheduled long before
+	// whatever is running now was started
+	var err error
+	if !j.opts.Force {
+		err = m.inhibitors.check(j.Action)
+	}
+	inhibited := err != nil
+	switch {
+	case inhibited:
+		slog.Warn("action inhibited", "action", j.Action, "job", j.ID, "request_id", j.RequestID, "error", err)
+		metrics.inc("winshut_power_actions_total", "action", j.Action, "result", "inhibited")
+	case m.dryRun:
+		slog.Info("dry-run, not executing", "action", j.Action, "job", j.ID, "request_id", j.RequestID)
+		metrics.inc("winshut_power_actions_total", "action", j.Action, "result", "dry_run")
+	default:
+		slog.Info("executing", "action", j.Action, "job", j.ID, "request_id", j.RequestID)
+		opts := j.opts
+		// force may have been accepted only to override inhibitors
+		opts.Force = opts.Force && slices.Contains(m.backend.Options(j.Action), "force")
+		err = m.backend.Execute(j.Action, opts)
+		result := "executed"
+		if err != nil {
+			result = "failed"
+		}
+		metrics.inc("winshut_power_actions_total", "action", j.Action, "result", result)
+	}
+
+	m.mu.Lock()
+	finished := time.Now()
+	j.FinishedAt = &finished
+	if err != nil {
+		if !inhibited {
+			slog.Error("failed to execute", "action", j.Action, "job", j.ID, "request_id", j.RequestID, "error", err)
+		}
+		j.State = jobFailed
+		j.Error = err.Error()
+	} else {
+		j.State = jobSucceeded
+	}
+	// The job has failed, but audit and webhooks tell an inhibited job apart
+	outcome := string(j.State)
+	if inhibited {
+		outcome = "inhibited"
+	}
+	done := *j
+	m.mu.Unlock()
+
+	m.record(done, done.requester(), outcome)
+}
+
+// refuse records a request for req that inhibitors refused with err before
+// it became a job.
+func (m *jobManager) refuse(req actionRequest, by requester, err error) {
+	m.record(job{Action: req.Action, Params: req.Params, DryRun: m.dryRun, Error: err.Error()}, by, "inhibited")
+}
+
+// cancel stops a pending job. It reports false if the job is unknown or is
+// no longer pending.
+func (m *jobManager) cancel(id string, by requester) (job, bool) {
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	if !ok || j.State != jobPending {
+		m.mu.Unlock()
+		return job{}, false
+	}
+	m.cancelLocked(j, by)
+	cancelled := *j
+	m.mu.Unlock()
+
+	m.record(cancelled, by, string(jobCancelled))
+	return cancelled, true
+}
+
+// cancelAll stops every pending job and returns them.
+func (m *jobManager) cancelAll(by requester) []job {
+	m.mu.Lock()
+	var cancelled []job
+	for _, id := range m.order {
+		if j := m.jobs[id]; j.State == jobPending {
+			m.cancelLocked(j, by)
+			cancelled = append(cancelled, *j)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, j := range cancelled {
+		m.record(j, by, string(jobCancelled))
+	}
+	return cancelled
+}
+
+// cancelLocked stops j, which must be pending. m.mu must be held; the caller
+// records the cancellation once it has released it.
+func (m *jobManager) cancelLocked(j *job, by requester) {
+	j.timer.Stop()
+	finished := time.Now()
+	j.State = jobCancelled
+	j.FinishedAt = &finished
+	slog.Info("cancelled", "action", j.Action, "job", j.ID, "cn", by.CN, "request_id", by.RequestID)
+}
+
+func (m *jobManager) get(id string) (job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return *j, true
+}
+
+// list returns all known jobs, newest first.
+func (m *jobManager) list() []job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]job, 0, len(m.order))
+	for _, id := range slices.Backward(m.order) {
+		jobs = append(jobs, *m.jobs[id])
+	}
+	return jobs
+}
+
+// prune drops the oldest finished jobs once the history is over its limit.
+// Pending and running jobs are never dropped.
+func (m *jobManager) prune() {
+	excess := len(m.order) - maxJobHistory
+	if excess <= 0 {
+		return
+	}
+	m.order = slices.DeleteFunc(m.order, func(id string) bool {
+		j := m.jobs[id]
+		if excess > 0 && j.FinishedAt != nil {
+			delete(m.jobs, id)
+			excess--
+			return true
+		}
+		return false
+	})
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
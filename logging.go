@@ -0,0 +1,216 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+)
+
+type logConfig struct {
+	File       string `yaml:"file"`
+	Format     string `yaml:"format"`      // text or json
+	Level      string `yaml:"level"`       // debug, info, warn or error
+	MaxSizeMB  int    `yaml:"max_size_mb"` // rotate the file at this size (0 disables)
+	MaxBackups int    `yaml:"max_backups"` // rotated files to keep
+}
+
+func (c *logConfig) validate() error {
+	var errs []error
+	if c.Format != "text" && c.Format != "json" {
+		errs = append(errs, fmt.Errorf("log.format: must be text or json, got %q", c.Format))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: must be debug, info, warn or error, got %q", c.Level))
+	}
+	if c.MaxSizeMB < 0 {
+		errs = append(errs, fmt.Errorf("log.max_size_mb: must not be negative, got %d", c.MaxSizeMB))
+	}
+	if c.MaxBackups < 0 {
+		errs = append(errs, fmt.Errorf("log.max_backups: must not be negative, got %d", c.MaxBackups))
+	}
+	return errors.Join(errs...)
+}
+
+// newLogHandler returns the handler for the configured format and level,
+// writing to w and tagging records with the request ID from their context.
+func newLogHandler(cfg logConfig, w io.Writer) slog.Handler {
+	return contextHandler{newFormatHandler(cfg, w)}
+}
+
+func newFormatHandler(cfg logConfig, w io.Writer) slog.Handler {
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.Level)) // checked by validate
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.Format == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// contextHandler adds the request ID, if any, to records logged with a
+// request's context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+type requestIDKey struct{}
+
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDMiddleware gives every request an ID, taken from X-Request-ID if
+// the client sent a sensible one, and echoes it in the response.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// validRequestID accepts up to 128 printable ASCII characters, which keeps
+// client-supplied IDs from forging log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range []byte(id) {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// rotatingFile is an append-only log file that is renamed to <path>.1 when
+// it would grow past maxSize, shifting older backups up to maxBackups.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f != nil && rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to rotate log file: %v\n", err)
+		}
+	}
+	if rf.f == nil {
+		// A failed rotation left no file open; try again for every line
+		// until the path can be opened
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups up, renames the current file to <path>.1 and
+// opens a new one. The current file is closed first, as Windows can't rename
+// an open file. If a rename fails the file is reopened where it is, so lines
+// keep going to it; if it can't be opened at all, rf.f is left nil and Write
+// retries.
+func (rf *rotatingFile) rotate() error {
+	var errs []error
+	if err := rf.f.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	rf.f = nil
+
+	backup := func(i int) string { return fmt.Sprintf("%s.%d", rf.path, i) }
+	for i := rf.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(backup(i), backup(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	if rf.maxBackups > 0 {
+		if err := os.Rename(rf.path, backup(1)); err != nil {
+			errs = append(errs, err)
+		}
+	} else if err := os.Remove(rf.path); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := rf.open(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return nil
+	}
+	return rf.f.Close()
+}
@@ -0,0 +1,74 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "winshut.log")
+	rf, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for suffix, want := range map[string]string{"": "four\nfive\n", ".1": "three\n", ".2": "one\ntwo\n"} {
+		if got := readTestFile(t, path+suffix); got != want {
+			t.Errorf("%s: got %q, want %q", filepath.Base(path+suffix), got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("kept more than max_backups files")
+	}
+}
+
+// If the file can't be moved aside, lines keep going to it.
+func TestRotatingFileRenameFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "winshut.log")
+	// A non-empty directory where the backup goes makes the rename fail
+	if err := os.MkdirAll(filepath.Join(path+".1", "x"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := openRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	for _, line := range []string{"one\n", "two\n", "three\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := readTestFile(t, path); !strings.HasSuffix(got, "three\n") {
+		t.Errorf("got %q, want the lines written after the failed rotation", got)
+	}
+	if err := rf.rotate(); err == nil {
+		t.Error("rotate didn't report the failed rename")
+	}
+	if _, err := rf.Write([]byte("four\n")); err != nil {
+		t.Fatalf("write after a failed rotation: %v", err)
+	}
+}
@@ -7,32 +7,26 @@ package main
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 	"time"
 )
 
-type serverConfig struct {
-	Addr       string
-	CertFile   string
-	KeyFile    string
-	CAFile     string
-	AllowCIDRs string
-	DryRun     bool
-}
-
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [command] [options]\n\n", os.Args[0])
 		fmt.Fprintln(os.Stderr, "Commands:")
 		fmt.Fprintln(os.Stderr, "  install    Install as a Windows service (flags are stored as service args)")
 		fmt.Fprintln(os.Stderr, "  remove     Remove the Windows service")
+		fmt.Fprintln(os.Stderr, "  audit      Verify an audit log (audit verify <file>)")
+		fmt.Fprintln(os.Stderr, "  pki        Create a CA and issue server/client certs (pki init|server|client)")
 		fmt.Fprintln(os.Stderr, "\nOptions:")
 		flag.PrintDefaults()
 	}
@@ -46,116 +40,285 @@ func main() {
 		case "remove":
 			serviceRemove()
 			return
+		case "audit":
+			auditCommand(os.Args[2:])
+			return
+		case "pki":
+			pkiCommand(os.Args[2:])
+			return
 		}
 	}
 
-	addr := flag.String("addr", "127.0.0.1:9090", "listen address")
-	certFile := flag.String("cert", "", "TLS certificate file (required)")
-	keyFile := flag.String("key", "", "TLS private key file (required)")
-	caFile := flag.String("ca", "", "CA certificate for mTLS client verification (required)")
+	configFile := flag.String("config", "", "YAML config file (flags override file values)")
+	addr := flag.String("addr", "127.0.0.1:9090", "listen address(es), comma-separated")
+	certFile := flag.String("cert", "", "TLS certificate file (required unless set in config)")
+	keyFile := flag.String("key", "", "TLS private key file (required unless set in config)")
+	caFile := flag.String("ca", "", "CA certificate for mTLS client verification (required unless set in config)")
 	allowCIDRs := flag.String("allow", "", "allowed client CIDRs, comma-separated (e.g. 192.168.1.0/24,10.0.0.0/8)")
+	backend := flag.String("backend", "", "power backend: auto, windows, systemd, or stub (default auto)")
 	dryRun := flag.Bool("dry-run", false, "log commands without executing")
 	flag.Parse()
 
 	// Catch subcommands placed after flags (e.g. winshut --cert ... install)
-	if arg := flag.Arg(0); arg == "install" || arg == "remove" {
+	if arg := flag.Arg(0); arg == "install" || arg == "remove" || arg == "audit" || arg == "pki" {
 		fmt.Fprintf(os.Stderr, "error: %q must be the first argument\n", arg)
 		fmt.Fprintf(os.Stderr, "usage: %s %s [options]\n", os.Args[0], arg)
 		os.Exit(1)
 	}
 
-	if *certFile == "" || *keyFile == "" || *caFile == "" {
-		fmt.Fprintln(os.Stderr, "error: --cert, --key, and --ca are required")
-		flag.Usage()
+	cfg := defaultServerConfig()
+	if *configFile != "" {
+		var err error
+		cfg, err = loadServerConfig(*configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Flags explicitly set on the command line override the config file
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Listen = splitList(*addr)
+		case "cert":
+			cfg.TLS.CertFile = *certFile
+		case "key":
+			cfg.TLS.KeyFile = *keyFile
+		case "ca":
+			cfg.TLS.CAFile = *caFile
+		case "allow":
+			cfg.Allow = splitList(*allowCIDRs)
+		case "backend":
+			cfg.Backend = *backend
+		case "dry-run":
+			cfg.DryRun = *dryRun
+		}
+	})
+
+	if err := cfg.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "error: invalid configuration:")
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(os.Stderr, "  %s\n", line)
+		}
 		os.Exit(1)
 	}
 
-	cfg := serverConfig{
-		Addr:       *addr,
-		CertFile:   *certFile,
-		KeyFile:    *keyFile,
-		CAFile:     *caFile,
-		AllowCIDRs: *allowCIDRs,
-		DryRun:     *dryRun,
+	var logOut io.Writer = os.Stderr
+	if cfg.Log.File != "" {
+		f, err := openRotatingFile(cfg.Log.File, int64(cfg.Log.MaxSizeMB)<<20, cfg.Log.MaxBackups)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to open log file: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		logOut = f
 	}
+	slog.SetDefault(slog.New(newLogHandler(cfg.Log, logOut)))
+	// Messages from the standard library, e.g. TLS handshake errors
+	slog.SetLogLoggerLevel(slog.LevelWarn)
 
-	server, err := buildServer(cfg)
+	server, bans, err := buildServer(cfg)
 	if err != nil {
-		log.Fatalf("failed to build server: %v", err)
+		slog.Error("failed to build server", "error", err)
+		os.Exit(1)
 	}
 
-	if err := runService(cfg, server); err != nil {
-		log.Fatalf("server error: %v", err)
+	if err := runService(cfg, server, bans); err != nil {
+		slog.Error("server error", "error", err)
+		os.Exit(1)
 	}
 }
 
-func buildServer(cfg serverConfig) (*http.Server, error) {
-	caCert, err := os.ReadFile(cfg.CAFile)
+// buildServer returns the server and the ban list its listeners must be
+// wrapped with, which is nil if banning is disabled.
+func buildServer(cfg serverConfig) (*http.Server, *banList, error) {
+	baseTLS := &tls.Config{
+		MinVersion: tls.VersionTLS13,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}
+	certs, err := newCertReloader(cfg.TLS, baseTLS)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read CA file: %w", err)
+		return nil, nil, err
 	}
-	caPool := x509.NewCertPool()
-	if !caPool.AppendCertsFromPEM(caCert) {
-		return nil, fmt.Errorf("failed to parse CA certificate")
+	if cfg.Revocation.enabled() {
+		revocation, err := newRevocationChecker(cfg.Revocation, certs.currentCACerts)
+		if err != nil {
+			return nil, nil, err
+		}
+		// The reloader clones baseTLS per handshake, and VerifyConnection runs
+		// on resumed sessions too, so this applies to every connection
+		baseTLS.VerifyConnection = revocation.verifyConnection
+		go revocation.watch(cfg.Revocation.ReloadInterval)
 	}
+	reload := make(chan os.Signal, 1)
+	reloadNotify(reload)
+	go certs.watch(cfg.TLS.ReloadInterval, reload)
 
-	tlsConfig := &tls.Config{
-		MinVersion: tls.VersionTLS13,
-		ClientCAs:  caPool,
-		ClientAuth: tls.RequireAndVerifyClientCert,
+	expiry := newCertMonitor(certs, cfg.TLS.ExpiryWarnings)
+	go expiry.watch()
+
+	cidrs, err := parseCIDRs(cfg.Allow)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Parse IP allowlist
-	var cidrs []*net.IPNet
-	if cfg.AllowCIDRs != "" {
-		for _, s := range strings.Split(cfg.AllowCIDRs, ",") {
-			_, cidr, err := net.ParseCIDR(strings.TrimSpace(s))
-			if err != nil {
-				return nil, fmt.Errorf("invalid CIDR %q: %w", strings.TrimSpace(s), err)
-			}
-			cidrs = append(cidrs, cidr)
+	bans, err := newBanList(cfg.Ban)
+	if err != nil {
+		return nil, nil, err
+	}
+	rl := newRateLimiter(cfg.RateLimit)
+	authz := newAuthzPolicy(cfg.Authz)
+	backend, err := newPowerBackend(cfg.Backend)
+	if err != nil {
+		return nil, nil, err
+	}
+	slog.Info("using power backend", "backend", backend.Name())
+	var audit *auditLog
+	if cfg.Audit.File != "" {
+		if audit, err = openAuditLog(cfg.Audit.File); err != nil {
+			return nil, nil, err
 		}
 	}
+	inhibitors := newInhibitors(cfg.Inhibitors)
+	jobs := newJobManager(backend, inhibitors, audit, newWebhookNotifier(cfg.Webhooks), cfg.DryRun)
+	confirm := newConfirmer(cfg.Confirm)
+
+	// Routes that may run an action inhibitors apply to can answer 409
+	var inhibitable []int
+	if inhibitors != nil {
+		inhibitable = []int{http.StatusConflict}
+	}
 
-	rl := newPowerRateLimiter(0.5, 2) // 1 action per 2s, burst of 2
+	api := newAPIRouter(authz, rl, bans)
+	api.handle(apiRoute{method: http.MethodGet, path: "/health", id: "getHealth", summary: "Liveness check", public: true, resp: response{}},
+		http.HandlerFunc(healthHandler))
+	api.handle(apiRoute{method: http.MethodGet, path: "/stats", id: "getStats", summary: "CPU, memory, uptime, and load", perm: "stats", resp: systemStats{}, errors: []int{http.StatusInternalServerError}},
+		http.HandlerFunc(statsHandler))
+	api.handle(apiRoute{method: http.MethodGet, path: "/metrics", id: "getMetrics", summary: "Prometheus metrics", perm: "metrics"},
+		metricsHandler(expiry))
+	api.handle(apiRoute{method: http.MethodGet, path: "/certs", id: "getCerts", summary: "Certificate expiry", perm: "certs", resp: []certInfo{}},
+		http.HandlerFunc(expiry.handler))
+	var enabled []string
+	for _, action := range cfg.Actions {
+		if !slices.Contains(backend.Capabilities(), action) {
+			slog.Info("backend does not support action, disabling it", "backend", backend.Name(), "action", action)
+			continue
+		}
+		enabled = append(enabled, action)
+		var errs []int
+		if inhibitors.covers(action) {
+			errs = inhibitable
+		}
+		// The original per-action routes remain as aliases of POST /v1/actions
+		api.handle(apiRoute{
+			method: http.MethodPost, path: "/" + action, id: action, summary: actionSummaries[action],
+			perm: action, power: true, action: action, params: jobs.actionParams(action), resp: response{}, confirm: confirm.required(action), errors: errs,
+		}, powerHandler(action, jobs, confirm, authz, cfg.DryRun))
+	}
+	api.handle(apiRoute{method: http.MethodGet, path: "/v1/actions", id: "listActions", summary: "Supported actions and their parameters", resp: actionList{}},
+		listActionsHandler(enabled, jobs))
+	api.handle(apiRoute{
+		method: http.MethodPost, path: "/v1/actions", id: "runAction", summary: "Run a power action; requires the permission named after the action",
+		power: true, body: actionBody{}, resp: response{}, confirm: slices.ContainsFunc(enabled, confirm.required),
+		errors: append([]int{http.StatusForbidden, http.StatusRequestEntityTooLarge}, inhibitable...),
+	}, postActionHandler(enabled, jobs, confirm, authz, rl, cfg.DryRun))
+	if len(cfg.Wake.Peers) > 0 {
+		api.handle(apiRoute{
+			method: http.MethodPost, path: "/wake", id: "wake", summary: "Send a Wake-on-LAN packet to a configured peer",
+			perm: "wake", power: true, action: "wake", params: []string{"peer", "mac"}, resp: response{}, errors: []int{http.StatusInternalServerError},
+		}, wakeHandler(cfg.Wake, audit, cfg.DryRun))
+	}
+	if bans != nil {
+		api.handle(apiRoute{method: http.MethodGet, path: "/bans", id: "listBans", summary: "Source IPs banned for repeated failures", perm: "bans", resp: []ban{}},
+			http.HandlerFunc(bans.listHandler))
+		api.handle(apiRoute{method: http.MethodDelete, path: "/bans", id: "clearBans", summary: "Lift every ban", perm: "bans", resp: response{}},
+			http.HandlerFunc(bans.unbanHandler))
+		api.handle(apiRoute{method: http.MethodDelete, path: "/bans/{ip}", id: "unban", summary: "Lift the ban on one IP", perm: "bans", resp: response{}, errors: []int{http.StatusNotFound}},
+			http.HandlerFunc(bans.unbanHandler))
+	}
+	if confirm != nil {
+		api.handle(apiRoute{method: http.MethodPost, path: "/confirm/{token}", id: "confirmAction", summary: "Run an action awaiting confirmation", resp: response{}, errors: append([]int{http.StatusBadRequest, http.StatusNotFound}, inhibitable...)},
+			confirm.handler(jobs, cfg.DryRun))
+	}
+	api.handle(apiRoute{method: http.MethodPost, path: "/cancel", id: "cancel", summary: "Cancel pending action(s)", perm: "cancel", params: []string{"id"}, resp: response{}, errors: []int{http.StatusNotFound}},
+		cancelHandler(jobs))
+	api.handle(apiRoute{method: http.MethodGet, path: "/jobs", id: "listJobs", summary: "List recent actions", perm: "jobs", resp: []job{}},
+		jobsHandler(jobs))
+	api.handle(apiRoute{method: http.MethodGet, path: "/jobs/{id}", id: "getJob", summary: "Status of one action", perm: "jobs", resp: job{}, errors: []int{http.StatusNotFound}},
+		jobHandler(jobs))
+	api.handle(apiRoute{method: http.MethodDelete, path: "/jobs/{id}", id: "cancelJob", summary: "Cancel a pending action", perm: "cancel", resp: response{}, errors: []int{http.StatusNotFound}},
+		cancelJobHandler(jobs))
 
 	mux := http.NewServeMux()
-	mux.Handle("/health", http.HandlerFunc(healthHandler))
-	mux.Handle("/stats", authMiddleware(http.HandlerFunc(statsHandler)))
-	for _, action := range []string{"shutdown", "restart", "hibernate", "sleep", "lock", "logoff", "screen-off"} {
-		mux.Handle("/"+action, authMiddleware(rl.middleware(powerHandler(action, cfg.DryRun))))
+	if err := api.install(mux); err != nil {
+		return nil, nil, err
 	}
 
 	var handler http.Handler = mux
 	if len(cidrs) > 0 {
-		handler = allowlistMiddleware(cidrs, mux)
+		handler = allowlistMiddleware(cidrs, bans, mux)
 	}
+	handler = requestIDMiddleware(metrics.middleware(expiry.middleware(handler)))
 
-	return &http.Server{
-		Addr:              cfg.Addr,
+	server := &http.Server{
 		Handler:           handler,
-		TLSConfig:         tlsConfig,
+		TLSConfig:         certs.tlsConfig(),
 		ReadTimeout:       15 * time.Second,
 		ReadHeaderTimeout: 5 * time.Second,
 		WriteTimeout:      15 * time.Second,
 		IdleTimeout:       60 * time.Second,
 		MaxHeaderBytes:    4096,
-	}, nil
+	}
+	if bans != nil {
+		server.ErrorLog = bans.errorLog()
+	}
+	return server, bans, nil
 }
 
-func runInteractive(cfg serverConfig, server *http.Server) error {
+// serve starts an HTTPS listener for every configured address. The returned
+// channel receives the first error from any listener other than a clean
+// shutdown.
+func serve(cfg serverConfig, server *http.Server, bans *banList) (<-chan error, error) {
+	var listeners []net.Listener
+	for _, addr := range cfg.Listen {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		listeners = append(listeners, bans.listener(ln))
+	}
+
+	errCh := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func() {
+			if err := server.ServeTLS(ln, "", ""); err != http.ErrServerClosed {
+				errCh <- err
+			}
+		}()
+	}
+	return errCh, nil
+}
+
+func runInteractive(cfg serverConfig, server *http.Server, bans *banList) error {
 	done := make(chan os.Signal, 1)
 	signalNotify(done)
 
-	go func() {
-		log.Printf("starting winshut on %s (dry-run=%v)", cfg.Addr, cfg.DryRun)
-		if err := server.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile); err != http.ErrServerClosed {
-			log.Fatalf("server error: %v", err)
-		}
-	}()
+	slog.Info("starting winshut", "listen", cfg.Listen, "dry_run", cfg.DryRun)
+	errCh, err := serve(cfg, server, bans)
+	if err != nil {
+		return err
+	}
 
-	<-done
-	log.Println("shutting down...")
+	select {
+	case err := <-errCh:
+		return err
+	case <-done:
+	}
+	slog.Info("shutting down")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -163,6 +326,6 @@ func runInteractive(cfg serverConfig, server *http.Server) error {
 	if err := server.Shutdown(ctx); err != nil {
 		return fmt.Errorf("shutdown error: %w", err)
 	}
-	log.Println("stopped")
+	slog.Info("stopped")
 	return nil
 }
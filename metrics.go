@@ -0,0 +1,160 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metrics collects server-side counters for /metrics. It is package-level so
+// the middlewares can record events without threading it through.
+var metrics = newServerMetrics()
+
+type serverMetrics struct {
+	mu       sync.Mutex
+	counters map[string]map[string]uint64 // metric name -> rendered labels -> value
+}
+
+// counterHelp describes every counter in the order they are exported.
+var counterHelp = []struct {
+	name, help string
+	labelled   bool
+}{
+	{"winshut_http_requests_total", "HTTP requests by route and status code.", true},
+	{"winshut_auth_failures_total", "Requests rejected for lacking a verified client certificate.", false},
+	{"winshut_allowlist_blocks_total", "Requests rejected by the IP allowlist.", false},
+	{"winshut_bans_total", "Source IPs banned, by the failure that tipped them over (handshake, unauthorized, allowlist).", true},
+	{"winshut_banned_connections_total", "Connections from banned IPs closed before the TLS handshake.", false},
+	{"winshut_rate_limited_total", "Requests rejected by the rate limiter, by class (power, read).", true},
+	{"winshut_power_actions_total", "Power actions run, by action and result (executed, failed, inhibited, dry_run).", true},
+	{"winshut_inhibited_total", "Power actions refused or failed because an inhibitor was active, by action.", true},
+	{"winshut_webhook_deliveries_total", "Webhook deliveries by result (delivered, failed, dropped).", true},
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{counters: make(map[string]map[string]uint64)}
+}
+
+// inc increments a counter. labels are alternating names and values.
+func (m *serverMetrics) inc(name string, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series, ok := m.counters[name]
+	if !ok {
+		series = make(map[string]uint64)
+		m.counters[name] = series
+	}
+	series[formatLabels(labels...)]++
+}
+
+func (m *serverMetrics) writeTo(buf *bytes.Buffer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range counterHelp {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		series := m.counters[c.name]
+		if len(series) == 0 && !c.labelled {
+			// Unlabelled counters are exported as zero until first use
+			fmt.Fprintf(buf, "%s 0\n", c.name)
+			continue
+		}
+		for _, labels := range slices.Sorted(maps.Keys(series)) {
+			fmt.Fprintf(buf, "%s%s %d\n", c.name, labels, series[labels])
+		}
+	}
+}
+
+// middleware counts every request by matched route pattern and status code.
+func (m *serverMetrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		route := r.Pattern // set by the ServeMux once it has matched
+		if route == "" {
+			route = "unmatched"
+		}
+		m.inc("winshut_http_requests_total", "route", route, "code", strconv.Itoa(rec.status))
+	})
+}
+
+func metricsHandler(certs *certMonitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, response{Status: "error", Message: "method not allowed"})
+			return
+		}
+
+		var buf bytes.Buffer
+		if stats, err := getSystemStats(); err != nil {
+			slog.ErrorContext(r.Context(), "failed to get system stats for metrics", "error", err)
+		} else {
+			writeStatsGauges(&buf, stats)
+		}
+		certs.writeGauges(&buf)
+		metrics.writeTo(&buf)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(buf.Bytes())
+	}
+}
+
+func writeStatsGauges(buf *bytes.Buffer, s *systemStats) {
+	gauge := func(name, help string, value float64) {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, strconv.FormatFloat(value, 'f', -1, 64))
+	}
+	gauge("winshut_cpu_usage_percent", "Host CPU usage.", s.CPUUsage)
+	gauge("winshut_memory_total_bytes", "Host physical memory.", float64(s.MemoryTotal))
+	gauge("winshut_memory_free_bytes", "Host free memory.", float64(s.MemoryFree))
+	gauge("winshut_memory_used_bytes", "Host used memory.", float64(s.MemoryUsed))
+	gauge("winshut_uptime_seconds", "Host uptime.", float64(s.UptimeSeconds))
+
+	if len(s.LoadAverage) == 3 {
+		fmt.Fprintf(buf, "# HELP winshut_load_average Host load average.\n# TYPE winshut_load_average gauge\n")
+		for i, period := range []string{"1m", "5m", "15m"} {
+			fmt.Fprintf(buf, "winshut_load_average%s %s\n", formatLabels("period", period), strconv.FormatFloat(s.LoadAverage[i], 'f', -1, 64))
+		}
+	}
+}
+
+// labelEscaper escapes a label value for the Prometheus text format, which
+// only knows these three escapes; %q would write others, such as \t and \x00,
+// that scrapers reject.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels ...string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var parts []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,25 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import "testing"
+
+func TestFormatLabels(t *testing.T) {
+	tests := []struct {
+		labels []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{"action", "shutdown"}, `{action="shutdown"}`},
+		{[]string{"cn", `C:\ops "admin"`, "path", "a\nb"}, `{cn="C:\\ops \"admin\"",path="a\nb"}`},
+		// Only \, " and newline are escaped; %q would have written \t
+		{[]string{"cn", "tab\there"}, "{cn=\"tab\there\"}"},
+	}
+	for _, tt := range tests {
+		if got := formatLabels(tt.labels...); got != tt.want {
+			t.Errorf("formatLabels(%q) = %s, want %s", tt.labels, got, tt.want)
+		}
+	}
+}
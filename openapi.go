@@ -0,0 +1,291 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// apiRoute describes one method of an endpoint. Routes are registered through
+// an apiRouter, which installs the handler with the middleware the route asks
+// for and describes it in the OpenAPI document from the same fields, so the
+// document can't disagree with what is served.
+type apiRoute struct {
+	method  string
+	path    string // ServeMux pattern; {name} segments are path parameters
+	id      string // OpenAPI operationId
+	summary string
+	public  bool     // served without authMiddleware or rate limit
+	perm    string   // permission required, if any
+	power   bool     // counts against the power rate limit instead of the read one
+	action  string   // power action, for rate limit keys; if empty on a power route, the handler checks the limit once it knows the action
+	params  []string // query or form parameters, described in apiParams
+	body    any      // JSON request body type, if any
+	resp    any      // JSON success response type; nil for text/plain
+	confirm bool     // may answer 202 with a confirmResponse instead
+	errors  []int    // statuses other than those implied by the fields above
+}
+
+// apiParams describes the query and form parameters used by routes.
+var apiParams = map[string]struct {
+	desc   string
+	schema map[string]any
+}{
+	"delay":   {"Seconds, or an RFC3339 time in the future, to wait before running the action.", map[string]any{"type": "string"}},
+	"force":   {"Don't wait for applications to close, and override inhibitors. Needs the force permission.", map[string]any{"type": "boolean"}},
+	"message": {"Shown to logged-in users before the action; on Windows, only recorded in the System event log.", map[string]any{"type": "string", "maxLength": maxMessageLen}},
+	"reason":  {"Recorded in the audit log, job, and webhooks.", map[string]any{"type": "string", "maxLength": maxMessageLen}},
+	"id":      {"Job ID; every pending job if omitted.", map[string]any{"type": "string"}},
+	"peer":    {"Name of a configured Wake-on-LAN peer.", map[string]any{"type": "string"}},
+	"mac":     {"MAC address of a configured Wake-on-LAN peer.", map[string]any{"type": "string"}},
+}
+
+// openAPISchemer is implemented by types whose JSON form can't be derived
+// from their Go type.
+type openAPISchemer interface {
+	openAPISchema() map[string]any
+}
+
+// apiRouter collects routes and installs them on a ServeMux.
+type apiRouter struct {
+	authz  *authzPolicy
+	rl     *rateLimiter
+	bans   *banList
+	routes []apiRoute
+	paths  map[string]map[string]http.Handler // path -> method -> handler
+}
+
+func newAPIRouter(authz *authzPolicy, rl *rateLimiter, bans *banList) *apiRouter {
+	return &apiRouter{authz: authz, rl: rl, bans: bans, paths: make(map[string]map[string]http.Handler)}
+}
+
+func (a *apiRouter) handle(rt apiRoute, h http.Handler) {
+	switch {
+	case rt.public:
+	case !rt.power:
+		h = a.rl.middleware(limitRead, rt.path, h)
+	case rt.action != "":
+		h = a.rl.middleware(limitPower, rt.action, h)
+	}
+	if rt.perm != "" {
+		h = a.authz.require(rt.perm, h)
+	}
+	if !rt.public {
+		h = authMiddleware(a.bans, h)
+	}
+	if a.paths[rt.path] == nil {
+		a.paths[rt.path] = make(map[string]http.Handler)
+	}
+	if _, ok := a.paths[rt.path][rt.method]; ok {
+		panic(fmt.Sprintf("duplicate route %s %s", rt.method, rt.path))
+	}
+	a.paths[rt.path][rt.method] = h
+	a.routes = append(a.routes, rt)
+}
+
+// install registers every route on mux, adding GET /openapi.json to serve
+// the document describing them.
+func (a *apiRouter) install(mux *http.ServeMux) error {
+	var doc []byte
+	a.handle(apiRoute{
+		method: http.MethodGet, path: "/openapi.json", id: "getOpenAPI",
+		summary: "This document",
+		resp:    map[string]any{},
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	}))
+
+	var err error
+	if doc, err = json.Marshal(a.document()); err != nil {
+		return fmt.Errorf("failed to generate OpenAPI document: %w", err)
+	}
+	for path, methods := range a.paths {
+		mux.Handle(path, byMethod(methods))
+	}
+	return nil
+}
+
+// document returns the OpenAPI 3.1 document for the registered routes.
+func (a *apiRouter) document() map[string]any {
+	schemas := make(map[string]any)
+	paths := make(map[string]map[string]any)
+	for _, rt := range a.routes {
+		if paths[rt.path] == nil {
+			paths[rt.path] = make(map[string]any)
+		}
+		paths[rt.path][strings.ToLower(rt.method)] = rt.operation(schemas)
+	}
+
+	version := "dev"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":       "winshut",
+			"description": "Remote power management over mutual TLS.",
+			"version":     version,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"mtls": map[string]any{"type": "mutualTLS", "description": "A client certificate signed by the server's client CA."},
+			},
+		},
+		"security": []any{map[string]any{"mtls": []string{}}},
+	}
+}
+
+func (rt apiRoute) operation(schemas map[string]any) map[string]any {
+	op := map[string]any{"operationId": rt.id, "summary": rt.summary}
+	if rt.public {
+		op["security"] = []any{}
+	}
+	if rt.perm != "" {
+		op["description"] = fmt.Sprintf("Requires the %q permission.", rt.perm)
+	}
+
+	var params []any
+	for _, seg := range strings.Split(rt.path, "/") {
+		if name, ok := strings.CutPrefix(seg, "{"); ok {
+			params = append(params, map[string]any{"name": strings.TrimSuffix(name, "}"), "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+		}
+	}
+	for _, name := range rt.params {
+		p, ok := apiParams[name]
+		if !ok {
+			panic(fmt.Sprintf("route %s %s: undocumented parameter %q", rt.method, rt.path, name))
+		}
+		params = append(params, map[string]any{"name": name, "in": "query", "description": p.desc, "schema": p.schema})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if rt.body != nil {
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{"application/json": map[string]any{"schema": schemaOf(reflect.TypeOf(rt.body), schemas)}},
+		}
+	}
+
+	responses := map[string]any{}
+	if rt.resp == nil {
+		responses["200"] = map[string]any{"description": "OK", "content": map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}}}
+	} else {
+		responses["200"] = jsonResponse("OK", rt.resp, schemas)
+	}
+	if rt.confirm {
+		responses["202"] = jsonResponse("Confirmation required", confirmResponse{}, schemas)
+	}
+	statuses := slices.Clone(rt.errors)
+	if len(rt.params) > 0 || rt.body != nil {
+		statuses = append(statuses, http.StatusBadRequest)
+	}
+	if !rt.public {
+		statuses = append(statuses, http.StatusUnauthorized)
+	}
+	if rt.perm != "" {
+		statuses = append(statuses, http.StatusForbidden)
+	}
+	if !rt.public {
+		statuses = append(statuses, http.StatusTooManyRequests)
+	}
+	for _, status := range statuses {
+		responses[fmt.Sprint(status)] = jsonResponse(http.StatusText(status), response{}, schemas)
+	}
+	op["responses"] = responses
+	return op
+}
+
+func jsonResponse(desc string, v any, schemas map[string]any) map[string]any {
+	return map[string]any{
+		"description": desc,
+		"content":     map[string]any{"application/json": map[string]any{"schema": schemaOf(reflect.TypeOf(v), schemas)}},
+	}
+}
+
+var (
+	schemerType = reflect.TypeFor[openAPISchemer]()
+	timeType    = reflect.TypeFor[time.Time]()
+)
+
+// schemaOf returns the JSON Schema for values of t as encoding/json writes
+// them. Named structs are added to schemas and referenced.
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	if t.Implements(schemerType) {
+		return reflect.Zero(t).Interface().(openAPISchemer).openAPISchema()
+	}
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem(), schemas)
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		name := []rune(t.Name())
+		name[0] = unicode.ToUpper(name[0])
+		ref := map[string]any{"$ref": "#/components/schemas/" + string(name)}
+		if _, ok := schemas[string(name)]; !ok {
+			schemas[string(name)] = nil // in case t refers to itself
+			schemas[string(name)] = structSchema(t, schemas)
+		}
+		return ref
+	default:
+		return map[string]any{}
+	}
+}
+
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	props := make(map[string]any)
+	var required []string
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemaOf(f.Type, schemas)
+		if !slices.Contains(strings.Split(opts, ","), "omitempty") {
+			required = append(required, name)
+		}
+	}
+	s := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
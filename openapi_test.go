@@ -0,0 +1,402 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomoconnor/winshut/client"
+)
+
+// apiContract calls a server's routes by operationId and checks every
+// response against the OpenAPI document the server serves.
+type apiContract struct {
+	t      *testing.T
+	client *http.Client
+	url    string
+	doc    map[string]any
+	ops    map[string]apiOperation
+	called map[string]bool
+}
+
+type apiOperation struct {
+	method string
+	path   string
+	op     map[string]any
+}
+
+func newAPIContract(t *testing.T, client *http.Client, url string) *apiContract {
+	t.Helper()
+	resp, err := client.Get(url + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var doc map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	c := &apiContract{t: t, client: client, url: url, doc: doc, ops: make(map[string]apiOperation), called: make(map[string]bool)}
+	for path, methods := range doc["paths"].(map[string]any) {
+		for method, op := range methods.(map[string]any) {
+			op := op.(map[string]any)
+			c.ops[op["operationId"].(string)] = apiOperation{method: strings.ToUpper(method), path: path, op: op}
+		}
+	}
+	return c
+}
+
+// call makes the request for operation id, filling its path parameters from
+// pathValues in order, and checks that it gets status want, that want is
+// documented, and that the body matches the documented schema. It returns
+// the decoded JSON body.
+func (c *apiContract) call(id, query, body string, want int, pathValues ...string) any {
+	c.t.Helper()
+	o, ok := c.ops[id]
+	if !ok {
+		c.t.Fatalf("%s: not in the document", id)
+	}
+	c.called[id] = true
+
+	var segs []string
+	for _, seg := range strings.Split(o.path, "/") {
+		if strings.HasPrefix(seg, "{") {
+			seg, pathValues = pathValues[0], pathValues[1:]
+		}
+		segs = append(segs, seg)
+	}
+	url := c.url + strings.Join(segs, "/")
+	if query != "" {
+		url += "?" + query
+	}
+	req, err := http.NewRequest(o.method, url, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	if resp.StatusCode != want {
+		c.t.Fatalf("%s %s: got %d, want %d: %s", o.method, url, resp.StatusCode, want, data)
+	}
+	doc, ok := o.op["responses"].(map[string]any)[fmt.Sprint(resp.StatusCode)].(map[string]any)
+	if !ok {
+		c.t.Fatalf("%s: status %d is not documented", id, resp.StatusCode)
+	}
+	content := doc["content"].(map[string]any)
+	if _, ok := content["text/plain"]; ok {
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+			c.t.Errorf("%s: got Content-Type %q, want text/plain", id, ct)
+		}
+		return nil
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		c.t.Errorf("%s: got Content-Type %q, want application/json", id, ct)
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		c.t.Fatalf("%s: %v: %s", id, err, data)
+	}
+	schema := content["application/json"].(map[string]any)["schema"].(map[string]any)
+	if err := checkSchema(c.doc, schema, v, id); err != nil {
+		c.t.Errorf("%s %d: %v", id, resp.StatusCode, err)
+	}
+	return v
+}
+
+// checkSchema reports the first way v doesn't match schema, using the
+// keywords schemaOf and the openAPISchema methods generate. Properties that
+// aren't documented are errors, so a response can't grow a field its type's
+// schema doesn't describe.
+func checkSchema(doc, schema map[string]any, v any, at string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		s, ok := doc["components"].(map[string]any)["schemas"].(map[string]any)[name].(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: unresolved %s", at, ref)
+		}
+		return checkSchema(doc, s, v, at)
+	}
+	if oneOf, ok := schema["oneOf"].([]any); ok {
+		matches := 0
+		for _, s := range oneOf {
+			if checkSchema(doc, s.(map[string]any), v, at) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: %v matches %d schemas of oneOf", at, v, matches)
+		}
+		return nil
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, v) {
+		return fmt.Errorf("%s: %v is not one of %v", at, v, enum)
+	}
+
+	switch schema["type"] {
+	case nil:
+		return nil
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: got %T, want object", at, v)
+		}
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing required property %q", at, name)
+			}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		extra, _ := schema["additionalProperties"].(map[string]any)
+		for _, name := range slices.Sorted(maps.Keys(obj)) {
+			s, ok := props[name].(map[string]any)
+			if !ok {
+				if extra == nil {
+					return fmt.Errorf("%s: undocumented property %q", at, name)
+				}
+				s = extra
+			}
+			if err := checkSchema(doc, s, obj[name], at+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: got %T, want array", at, v)
+		}
+		for i, item := range arr {
+			if err := checkSchema(doc, schema["items"].(map[string]any), item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: got %T, want string", at, v)
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				return fmt.Errorf("%s: %w", at, err)
+			}
+		}
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok {
+			return fmt.Errorf("%s: got %T, want %s", at, v, schema["type"])
+		}
+		if schema["type"] == "integer" && n != math.Trunc(n) {
+			return fmt.Errorf("%s: %v is not an integer", at, n)
+		}
+		if min, ok := schema["minimum"].(float64); ok && n < min {
+			return fmt.Errorf("%s: %v is below the minimum %v", at, n, min)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: got %T, want boolean", at, v)
+		}
+	default:
+		return fmt.Errorf("%s: unknown type %v", at, schema["type"])
+	}
+	return nil
+}
+
+// Every documented route is called on a server with every optional feature
+// enabled, and every response must be documented.
+func TestOpenAPIContract(t *testing.T) {
+	dir := testPKI(t, "client")
+	lock := filepath.Join(dir, "backup.lock")
+	if err := os.WriteFile(lock, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testServerConfig(dir)
+	cfg.Confirm.Actions = []string{"lock"}
+	cfg.Inhibitors = []inhibitorConfig{{File: lock, Actions: []string{"sleep"}}}
+	cfg.Wake.Peers = map[string]wakePeer{"render-01": {MAC: "00:1a:2b:3c:4d:5e"}}
+	cfg.Ban.MaxFailures = 5
+	cfg.RateLimit.Key = []string{"identity", "action"}
+	c := newAPIContract(t, testClient(t, dir, "client"), startTestServer(t, cfg))
+
+	c.call("getOpenAPI", "", "", http.StatusOK)
+	c.call("getHealth", "", "", http.StatusOK)
+	c.call("getStats", "", "", http.StatusOK)
+	c.call("getMetrics", "", "", http.StatusOK)
+	c.call("getCerts", "", "", http.StatusOK)
+	c.call("listActions", "", "", http.StatusOK)
+
+	// A delay keeps the job pending so it can be looked up and cancelled
+	shutdown := c.call("shutdown", "delay=600", "", http.StatusOK).(map[string]any)["job_id"].(string)
+	c.call("runAction", "", `{"action":"shutdown","delay":600,"message":"contract test"}`, http.StatusOK)
+	c.call("runAction", "", `{"action":"explode"}`, http.StatusBadRequest)
+	c.call("restart", "delay=600&reason=contract+test", "", http.StatusOK)
+	c.call("restart", "delay=soon", "", http.StatusBadRequest)
+	c.call("restart", "delay=600", "", http.StatusTooManyRequests)
+	for _, action := range []string{"hibernate", "logoff", "screen-off"} {
+		c.call(action, "", "", http.StatusOK)
+	}
+	c.call("sleep", "", "", http.StatusConflict)
+	c.call("sleep", "force=true", "", http.StatusOK)
+
+	token := c.call("lock", "", "", http.StatusAccepted).(map[string]any)["token"].(string)
+	c.call("confirmAction", "", "", http.StatusOK, token)
+	c.call("confirmAction", "", "", http.StatusNotFound, token)
+
+	c.call("listJobs", "", "", http.StatusOK)
+	c.call("getJob", "", "", http.StatusOK, shutdown)
+	c.call("getJob", "", "", http.StatusNotFound, "missing")
+	c.call("cancelJob", "", "", http.StatusOK, shutdown)
+	c.call("cancelJob", "", "", http.StatusNotFound, shutdown)
+	c.call("cancel", "id=missing", "", http.StatusNotFound)
+	c.call("cancel", "", "", http.StatusOK)
+
+	c.call("wake", "peer=render-01", "", http.StatusOK)
+	c.call("wake", "peer=missing", "", http.StatusBadRequest)
+
+	c.call("listBans", "", "", http.StatusOK)
+	c.call("unban", "", "", http.StatusNotFound, "192.0.2.1")
+	c.call("clearBans", "", "", http.StatusOK)
+
+	for _, id := range slices.Sorted(maps.Keys(c.ops)) {
+		if !c.called[id] {
+			t.Errorf("%s %s (%s) is documented but not exercised", c.ops[id].method, c.ops[id].path, id)
+		}
+	}
+}
+
+// checkSchema must catch responses that drift from their documented type.
+func TestCheckSchemaRejectsDrift(t *testing.T) {
+	api := newAPIRouter(nil, nil, nil)
+	api.handle(apiRoute{method: http.MethodGet, path: "/jobs/{id}", id: "getJob", resp: job{}}, http.NotFoundHandler())
+	data, err := json.Marshal(api.document())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	schema := map[string]any{"$ref": "#/components/schemas/Job"}
+
+	valid := `{"id":"a1","action":"shutdown","state":"pending","created_at":"2025-01-02T03:04:05Z","run_at":"2025-01-02T03:04:05Z","dry_run":true}`
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"valid", valid, ""},
+		{"missing required field", strings.Replace(valid, `"state":"pending",`, "", 1), `missing required property "state"`},
+		{"undocumented field", strings.Replace(valid, `"id":"a1"`, `"id":"a1","owner":"bob"`, 1), `undocumented property "owner"`},
+		{"wrong type", strings.Replace(valid, `"dry_run":true`, `"dry_run":"yes"`, 1), "want boolean"},
+		{"not in enum", strings.Replace(valid, `"pending"`, `"exploded"`, 1), "is not one of"},
+		{"bad time", strings.Replace(valid, `"2025-01-02T03:04:05Z",`, `"yesterday",`, 1), "created_at"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v any
+			if err := json.Unmarshal([]byte(tt.body), &v); err != nil {
+				t.Fatal(err)
+			}
+			err := checkSchema(doc, schema, v, "job")
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// The client package decodes responses into its own types, so check that
+// each has exactly the properties of the schema it stands for in the
+// document the server serves, with the same types. Enums are dropped, as the
+// client keeps those fields as plain strings.
+func TestClientMatchesOpenAPI(t *testing.T) {
+	dir := testPKI(t, "client")
+	cfg := testServerConfig(dir)
+	cfg.Confirm.Actions = []string{"lock"}
+	cfg.Ban.MaxFailures = 5
+	c := newAPIContract(t, testClient(t, dir, "client"), startTestServer(t, cfg))
+	serverSchemas := c.doc["components"].(map[string]any)["schemas"].(map[string]any)
+
+	tests := []struct {
+		client  reflect.Type
+		schemas []string // the properties of all of these
+	}{
+		{reflect.TypeFor[client.Response](), []string{"Response"}},
+		{reflect.TypeFor[client.ActionResult](), []string{"Response", "ConfirmResponse"}},
+		{reflect.TypeFor[client.Stats](), []string{"SystemStats"}},
+		{reflect.TypeFor[client.CertInfo](), []string{"CertInfo"}},
+		{reflect.TypeFor[client.Job](), []string{"Job"}},
+		{reflect.TypeFor[client.Ban](), []string{"Ban"}},
+		{reflect.TypeFor[client.ActionList](), []string{"ActionList"}},
+		{reflect.TypeFor[client.ActionInfo](), []string{"ActionInfo"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.client.Name(), func(t *testing.T) {
+			want := make(map[string]any)
+			for _, name := range tt.schemas {
+				s, ok := serverSchemas[name].(map[string]any)
+				if !ok {
+					t.Fatalf("%s is not in the document", name)
+				}
+				for prop, ps := range s["properties"].(map[string]any) {
+					ps := maps.Clone(ps.(map[string]any))
+					delete(ps, "enum")
+					want[prop] = ps
+				}
+			}
+
+			clientSchemas := make(map[string]any)
+			schemaOf(tt.client, clientSchemas)
+			data, err := json.Marshal(clientSchemas[tt.client.Name()])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			props := got["properties"].(map[string]any)
+
+			for _, prop := range slices.Sorted(maps.Keys(want)) {
+				switch {
+				case props[prop] == nil:
+					t.Errorf("%s is missing %q", tt.client, prop)
+				case !reflect.DeepEqual(props[prop], want[prop]):
+					t.Errorf("%s.%s: got schema %v, want %v", tt.client, prop, props[prop], want[prop])
+				}
+			}
+			for _, prop := range slices.Sorted(maps.Keys(props)) {
+				if want[prop] == nil {
+					t.Errorf("%s has %q, which the server doesn't send", tt.client, prop)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// pkiCommand implements "winshut pki init|server|client", which replace the
+// openssl recipe that used to live in the Makefile.
+func pkiCommand(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: winshut pki <init|server|client> [options]")
+		fmt.Fprintln(os.Stderr, "  init     Create a CA certificate and key")
+		fmt.Fprintln(os.Stderr, "  server   Issue a server certificate (--san host,ip,...)")
+		fmt.Fprintln(os.Stderr, "  client   Issue a client certificate (--name cn [--role role,...])")
+		fmt.Fprintln(os.Stderr, "Run 'winshut pki <command> --help' for options.")
+	}
+	if len(args) < 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	var err error
+	switch args[0] {
+	case "init":
+		err = pkiInit(args[1:])
+	case "server":
+		err = pkiServer(args[1:])
+	case "client":
+		err = pkiClient(args[1:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// pkiFlags are the options shared by every pki subcommand.
+type pkiFlags struct {
+	dir     *string
+	days    *int
+	keyType *string
+	force   *bool
+}
+
+func newPKIFlagSet(name string, defaultDays int) (*flag.FlagSet, pkiFlags) {
+	fs := flag.NewFlagSet("pki "+name, flag.ExitOnError)
+	return fs, pkiFlags{
+		dir:     fs.String("dir", "certs", "directory for certificates and keys"),
+		days:    fs.Int("days", defaultDays, "certificate lifetime in days"),
+		keyType: fs.String("key-type", "p256", "key type: p256, ed25519, or rsa"),
+		force:   fs.Bool("force", false, "overwrite existing files"),
+	}
+}
+
+func pkiInit(args []string) error {
+	fs, opts := newPKIFlagSet("init", 3650)
+	cn := fs.String("cn", "WinShut CA", "CA common name")
+	fs.Parse(args)
+
+	key, err := generateKey(*opts.keyType)
+	if err != nil {
+		return err
+	}
+	tmpl, err := newCertTemplate(*cn, *opts.days)
+	if err != nil {
+		return err
+	}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.MaxPathLenZero = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	return writeCertAndKey(*opts.dir, "ca", der, key, *opts.force)
+}
+
+func pkiServer(args []string) error {
+	fs, opts := newPKIFlagSet("server", 365)
+	cn := fs.String("cn", "winshut", "server common name")
+	var sans []string
+	fs.Func("san", "hostname or IP for the certificate, comma-separated or repeated (required)", func(v string) error {
+		sans = append(sans, splitList(v)...)
+		return nil
+	})
+	fs.Parse(args)
+
+	if len(sans) == 0 {
+		return errors.New("at least one --san is required")
+	}
+
+	tmpl, err := newCertTemplate(*cn, *opts.days)
+	if err != nil {
+		return err
+	}
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, san := range sans {
+		if ip := net.ParseIP(san); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, san)
+		}
+	}
+	return issueLeaf(opts, "server", tmpl)
+}
+
+func pkiClient(args []string) error {
+	fs, opts := newPKIFlagSet("client", 365)
+	name := fs.String("name", "", "client common name (required)")
+	out := fs.String("out", "", "base file name for the cert and key (default: the --name)")
+	var roles []string
+	fs.Func("role", "role for authz rules, stored as an OU; comma-separated or repeated", func(v string) error {
+		roles = append(roles, splitList(v)...)
+		return nil
+	})
+	fs.Parse(args)
+
+	if *name == "" {
+		return errors.New("--name is required")
+	}
+	if *out == "" {
+		*out = *name
+	}
+
+	tmpl, err := newCertTemplate(*name, *opts.days)
+	if err != nil {
+		return err
+	}
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	tmpl.Subject.OrganizationalUnit = roles
+	return issueLeaf(opts, *out, tmpl)
+}
+
+// issueLeaf signs tmpl with the CA in opts.dir and writes <base>.crt/.key.
+func issueLeaf(opts pkiFlags, base string, tmpl *x509.Certificate) error {
+	ca, err := tls.LoadX509KeyPair(filepath.Join(*opts.dir, "ca.crt"), filepath.Join(*opts.dir, "ca.key"))
+	if err != nil {
+		return fmt.Errorf("failed to load CA (run 'winshut pki init' first): %w", err)
+	}
+
+	key, err := generateKey(*opts.keyType)
+	if err != nil {
+		return err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	if tmpl.NotAfter.After(ca.Leaf.NotAfter) {
+		fmt.Fprintf(os.Stderr, "warning: certificate outlives the CA, which expires %s\n", ca.Leaf.NotAfter.Format(time.DateOnly))
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Leaf, key.Public(), ca.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %w", err)
+	}
+	return writeCertAndKey(*opts.dir, base, der, key, *opts.force)
+}
+
+func newCertTemplate(cn string, days int) (*x509.Certificate, error) {
+	if days < 1 {
+		return nil, fmt.Errorf("--days must be at least 1, got %d", days)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    now.Add(-5 * time.Minute), // tolerate small clock skew
+		NotAfter:     now.AddDate(0, 0, days),
+	}, nil
+}
+
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "p256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	case "rsa":
+		return rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return nil, fmt.Errorf("unknown key type %q (valid: p256, ed25519, rsa)", keyType)
+	}
+}
+
+func writeCertAndKey(dir, base string, der []byte, key crypto.Signer, force bool) error {
+	certPath := filepath.Join(dir, base+".crt")
+	keyPath := filepath.Join(dir, base+".key")
+	if !force {
+		for _, path := range []string{certPath, keyPath} {
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("%s already exists (use --force to overwrite)", path)
+			}
+		}
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode private key: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s and %s\n", certPath, keyPath)
+	return nil
+}
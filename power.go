@@ -0,0 +1,72 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// ActionOptions modify how a backend carries out an action.
+type ActionOptions struct {
+	Force   bool   // don't wait for applications or inhibitors
+	Message string // shown to logged-in users beforehand
+}
+
+// PowerBackend carries out power actions on the host.
+type PowerBackend interface {
+	// Name identifies the backend in the config file and logs.
+	Name() string
+	// Capabilities lists the actions Execute supports.
+	Capabilities() []string
+	// Options lists the ActionOptions honoured for action, by parameter
+	// name ("force", "message").
+	Options(action string) []string
+	Execute(action string, opts ActionOptions) error
+}
+
+// powerBackends holds the backends that can be selected by name on this
+// platform. Platform files register their own in init.
+var powerBackends = map[string]func() (PowerBackend, error){
+	"stub": func() (PowerBackend, error) { return stubBackend{}, nil },
+}
+
+// newPowerBackend returns the named backend, or the platform default for ""
+// and "auto".
+func newPowerBackend(name string) (PowerBackend, error) {
+	if name == "" || name == "auto" {
+		return defaultPowerBackend()
+	}
+	newBackend, ok := powerBackends[name]
+	if !ok {
+		return nil, fmt.Errorf("power backend %q is not available on %s", name, runtime.GOOS)
+	}
+	return newBackend()
+}
+
+func powerBackendNames() string {
+	return strings.Join(slices.Sorted(maps.Keys(powerBackends)), ", ")
+}
+
+// stubBackend only logs, for platforms without a real backend and for testing.
+type stubBackend struct{}
+
+func (stubBackend) Name() string { return "stub" }
+
+func (stubBackend) Capabilities() []string { return powerActions }
+
+func (stubBackend) Options(string) []string { return []string{"force", "message"} }
+
+func (stubBackend) Execute(action string, opts ActionOptions) error {
+	if !slices.Contains(powerActions, action) {
+		return fmt.Errorf("unknown action: %s", action)
+	}
+	slog.Info("stub backend, not executing", "action", action, "force", opts.Force, "message", opts.Message)
+	return nil
+}
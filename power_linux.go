@@ -0,0 +1,124 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func init() {
+	powerBackends["systemd"] = newSystemdBackend
+}
+
+// defaultPowerBackend uses systemd. There is no fallback to the stub, which
+// would report actions as done without doing anything; it has to be chosen
+// explicitly, e.g. in containers.
+func defaultPowerBackend() (PowerBackend, error) {
+	b, err := newSystemdBackend()
+	if err != nil {
+		return nil, fmt.Errorf("systemd backend unavailable (set backend: stub to run without one): %w", err)
+	}
+	return b, nil
+}
+
+// systemdBackend drives power state through systemctl and sessions through
+// loginctl. Turning the screen off has no session-independent equivalent, so
+// screen-off is not supported.
+type systemdBackend struct{}
+
+func newSystemdBackend() (PowerBackend, error) {
+	for _, tool := range []string{"systemctl", "loginctl"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return nil, err
+		}
+	}
+	return systemdBackend{}, nil
+}
+
+func (systemdBackend) Name() string { return "systemd" }
+
+func (systemdBackend) Capabilities() []string {
+	return []string{"shutdown", "restart", "hibernate", "sleep", "lock", "logoff"}
+}
+
+// Options reports --ignore-inhibitors as force for the systemctl actions, and
+// --message, which systemd only shows for poweroff and reboot.
+func (systemdBackend) Options(action string) []string {
+	switch action {
+	case "shutdown", "restart":
+		return []string{"force", "message"}
+	case "hibernate", "sleep":
+		return []string{"force"}
+	default:
+		return nil
+	}
+}
+
+func (systemdBackend) Execute(action string, opts ActionOptions) error {
+	var args []string
+	if opts.Force {
+		args = append(args, "--ignore-inhibitors")
+	}
+	if opts.Message != "" {
+		args = append(args, "--message="+opts.Message)
+	}
+
+	switch action {
+	case "shutdown":
+		return runCommand("systemctl", append([]string{"poweroff"}, args...)...)
+	case "restart":
+		return runCommand("systemctl", append([]string{"reboot"}, args...)...)
+	case "hibernate":
+		return runCommand("systemctl", append([]string{"hibernate"}, args...)...)
+	case "sleep":
+		return runCommand("systemctl", append([]string{"suspend"}, args...)...)
+	case "lock":
+		return runCommand("loginctl", "lock-sessions")
+	case "logoff":
+		return logoffActiveUser()
+	default:
+		return fmt.Errorf("unknown action: %s", action)
+	}
+}
+
+// logoffActiveUser terminates the user owning the active session on seat0,
+// which is the Linux equivalent of logging off the console user.
+func logoffActiveUser() error {
+	session, err := commandOutput("loginctl", "show-seat", "seat0", "--property=ActiveSession", "--value")
+	if err != nil {
+		return err
+	}
+	if session == "" {
+		return fmt.Errorf("no active session on seat0")
+	}
+	user, err := commandOutput("loginctl", "show-session", session, "--property=Name", "--value")
+	if err != nil {
+		return err
+	}
+	return runCommand("loginctl", "terminate-user", user)
+}
+
+// runCommand executes a command and includes its stderr in the error, so
+// failures show up in the job status rather than just an exit code.
+func runCommand(name string, args ...string) error {
+	_, err := commandOutput(name, args...)
+	return err
+}
+
+func commandOutput(name string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
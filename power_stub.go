@@ -2,21 +2,17 @@
 // License, v. 2.0. If a copy of the MPL was not distributed with this
 // file, You can obtain one at https://mozilla.org/MPL/2.0/.
 
-//go:build !windows
+//go:build !windows && !linux
 
 package main
 
 import (
 	"fmt"
-	"log"
+	"runtime"
 )
 
-func execPowerCommand(action string) error {
-	switch action {
-	case "shutdown", "restart", "hibernate", "sleep", "lock", "logoff", "screen-off":
-		log.Printf("[stub] would execute power command: %s", action)
-		return nil
-	default:
-		return fmt.Errorf("unknown action: %s", action)
-	}
+// defaultPowerBackend fails, since there is no native backend here and the
+// stub, which only logs, has to be chosen explicitly.
+func defaultPowerBackend() (PowerBackend, error) {
+	return nil, fmt.Errorf("no native power backend on %s (set backend: stub to run without one)", runtime.GOOS)
 }
@@ -18,20 +18,57 @@ var (
 	procSendMessage = user32.NewProc("SendMessageW")
 )
 
-func execPowerCommand(action string) error {
+func init() {
+	powerBackends["windows"] = func() (PowerBackend, error) { return windowsBackend{}, nil }
+}
+
+func defaultPowerBackend() (PowerBackend, error) {
+	return windowsBackend{}, nil
+}
+
+type windowsBackend struct{}
+
+func (windowsBackend) Name() string { return "windows" }
+
+func (windowsBackend) Capabilities() []string { return powerActions }
+
+// Options reports the shutdown.exe /f and /c flags as force and message.
+// Actions run with /t 0, and Windows only displays the /c comment during a
+// countdown, so message is never shown to users; it is recorded as the
+// shutdown comment of event 1074 in the System event log.
+func (windowsBackend) Options(action string) []string {
+	switch action {
+	case "shutdown", "restart":
+		return []string{"force", "message"}
+	case "hibernate", "logoff":
+		return []string{"force"}
+	default:
+		return nil
+	}
+}
+
+func (windowsBackend) Execute(action string, opts ActionOptions) error {
+	var args []string
+	if opts.Force {
+		args = append(args, "/f")
+	}
+	if opts.Message != "" {
+		args = append(args, "/c", opts.Message)
+	}
+
 	switch action {
 	case "shutdown":
-		return exec.Command("shutdown", "/s", "/t", "0").Run()
+		return exec.Command("shutdown", append([]string{"/s", "/t", "0"}, args...)...).Run()
 	case "restart":
-		return exec.Command("shutdown", "/r", "/t", "0").Run()
+		return exec.Command("shutdown", append([]string{"/r", "/t", "0"}, args...)...).Run()
 	case "hibernate":
-		return exec.Command("shutdown", "/h").Run()
+		return exec.Command("shutdown", append([]string{"/h"}, args...)...).Run()
 	case "sleep":
 		return exec.Command("rundll32.exe", "powrprof.dll,SetSuspendState", "0,1,0").Run()
 	case "lock":
 		return exec.Command("rundll32.exe", "user32.dll,LockWorkStation").Run()
 	case "logoff":
-		return exec.Command("shutdown", "/l").Run()
+		return exec.Command("shutdown", append([]string{"/l"}, args...)...).Run()
 	case "screen-off":
 		return screenOff()
 	default:
@@ -41,10 +78,10 @@ func execPowerCommand(action string) error {
 
 func screenOff() error {
 	procSendMessage.Call(
-		0xFFFF,  // HWND_BROADCAST
-		0x0112,  // WM_SYSCOMMAND
-		0xF170,  // SC_MONITORPOWER
-		2,       // MONITOR_OFF
+		0xFFFF, // HWND_BROADCAST
+		0x0112, // WM_SYSCOMMAND
+		0xF170, // SC_MONITORPOWER
+		2,      // MONITOR_OFF
 	)
 	return nil
 }
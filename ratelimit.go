@@ -5,51 +5,176 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
-type powerRateLimiter struct {
-	mu     sync.Mutex
+// rateLimitKeys are the request properties buckets can be keyed by.
+var rateLimitKeys = []string{"identity", "ip", "action"}
+
+// Rate limit classes: power requests and everything else that needs a
+// client certificate.
+const (
+	limitPower = "power"
+	limitRead  = "read"
+)
+
+type rateLimitConfig struct {
+	Rate        float64       `yaml:"rate"` // power requests per second, per bucket
+	Burst       int           `yaml:"burst"`
+	ReadRate    float64       `yaml:"read_rate"` // other requests per second, per bucket
+	ReadBurst   int           `yaml:"read_burst"`
+	Key         []string      `yaml:"key"`          // what a bucket is per: identity, ip and/or action
+	IdleTimeout time.Duration `yaml:"idle_timeout"` // forget full buckets unused this long
+}
+
+func (c *rateLimitConfig) validate() error {
+	var errs []error
+	if c.Rate <= 0 {
+		errs = append(errs, fmt.Errorf("rate_limit.rate: must be positive, got %v", c.Rate))
+	}
+	if c.Burst < 1 {
+		errs = append(errs, fmt.Errorf("rate_limit.burst: must be at least 1, got %d", c.Burst))
+	}
+	if c.ReadRate <= 0 {
+		errs = append(errs, fmt.Errorf("rate_limit.read_rate: must be positive, got %v", c.ReadRate))
+	}
+	if c.ReadBurst < 1 {
+		errs = append(errs, fmt.Errorf("rate_limit.read_burst: must be at least 1, got %d", c.ReadBurst))
+	}
+	for i, k := range c.Key {
+		if !slices.Contains(rateLimitKeys, k) {
+			errs = append(errs, fmt.Errorf("rate_limit.key[%d]: unknown key %q (valid: %s)", i, k, strings.Join(rateLimitKeys, ", ")))
+		}
+	}
+	if c.IdleTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("rate_limit.idle_timeout: must be positive, got %s", c.IdleTimeout))
+	}
+	return errors.Join(errs...)
+}
+
+// rateLimiter keeps a token bucket per class and key, so that one busy
+// client doesn't use up everyone's allowance. Buckets are created full on
+// first use and dropped again once they have refilled and sat idle.
+type rateLimiter struct {
+	cfg rateLimitConfig
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
 	tokens float64
 	last   time.Time
-	rate   float64 // tokens per second
-	burst  float64
 }
 
-func newPowerRateLimiter(rate float64, burst int) *powerRateLimiter {
-	return &powerRateLimiter{
-		tokens: float64(burst),
-		last:   time.Now(),
-		rate:   rate,
-		burst:  float64(burst),
+func newRateLimiter(cfg rateLimitConfig) *rateLimiter {
+	return &rateLimiter{cfg: cfg, buckets: make(map[string]*tokenBucket), lastSweep: time.Now()}
+}
+
+func (l *rateLimiter) limits(class string) (rate, burst float64) {
+	if class == limitPower {
+		return l.cfg.Rate, float64(l.cfg.Burst)
+	}
+	return l.cfg.ReadRate, float64(l.cfg.ReadBurst)
+}
+
+// key identifies the bucket for a request. action is the power action, or
+// the route for read requests.
+func (l *rateLimiter) key(r *http.Request, class, action string) string {
+	parts := []string{class}
+	for _, k := range l.cfg.Key {
+		switch k {
+		case "identity":
+			if id := identityFromContext(r.Context()); id != nil {
+				parts = append(parts, id.Fingerprint)
+			} else {
+				parts = append(parts, "-")
+			}
+		case "ip":
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			parts = append(parts, host)
+		case "action":
+			parts = append(parts, action)
+		}
 	}
+	return strings.Join(parts, "|")
 }
 
-func (l *powerRateLimiter) allow() bool {
+// take removes a token from the bucket for key if it has one, and returns
+// the tokens left.
+func (l *rateLimiter) take(key, class string) (ok bool, tokens float64) {
+	rate, burst := l.limits(class)
+	now := time.Now()
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) >= l.cfg.IdleTimeout {
+		l.sweep(now)
+	}
+	b, found := l.buckets[key]
+	if !found {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*rate, burst)
+	b.last = now
+	if b.tokens < 1 {
+		return false, b.tokens
+	}
+	b.tokens--
+	return true, b.tokens
+}
 
-	now := time.Now()
-	l.tokens += now.Sub(l.last).Seconds() * l.rate
-	l.last = now
-	if l.tokens > l.burst {
-		l.tokens = l.burst
+// sweep drops buckets that have been idle for the idle timeout and are full
+// again, so forgetting them changes nothing. l.mu must be held.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		class, _, _ := strings.Cut(key, "|")
+		rate, burst := l.limits(class)
+		idle := now.Sub(b.last)
+		if idle >= l.cfg.IdleTimeout && b.tokens+idle.Seconds()*rate >= burst {
+			delete(l.buckets, key)
+		}
 	}
-	if l.tokens < 1 {
-		return false
+	l.lastSweep = now
+}
+
+// check takes a token for the request and sets the RateLimit-* headers. If
+// the bucket is empty it writes a 429 with Retry-After and returns false.
+func (l *rateLimiter) check(w http.ResponseWriter, r *http.Request, class, action string) bool {
+	ok, tokens := l.take(l.key(r, class, action), class)
+	rate, burst := l.limits(class)
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(int(burst)))
+	h.Set("RateLimit-Remaining", strconv.Itoa(int(tokens)))
+	h.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil((burst-tokens)/rate))))
+	if ok {
+		return true
 	}
-	l.tokens--
-	return true
+	metrics.inc("winshut_rate_limited_total", "class", class)
+	h.Set("Retry-After", strconv.Itoa(int(math.Ceil((1-tokens)/rate))))
+	writeJSON(w, http.StatusTooManyRequests, response{Status: "error", Message: "rate limit exceeded"})
+	return false
 }
 
-func (l *powerRateLimiter) middleware(next http.Handler) http.Handler {
+// middleware rate limits next in class. action is used for the action key.
+func (l *rateLimiter) middleware(class, action string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !l.allow() {
-			writeJSON(w, http.StatusTooManyRequests, response{Status: "error", Message: "rate limit exceeded"})
-			return
+		if l.check(w, r, class, action) {
+			next.ServeHTTP(w, r)
 		}
-		next.ServeHTTP(w, r)
 	})
 }
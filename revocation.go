@@ -0,0 +1,240 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type revocationConfig struct {
+	CRLs     []string `yaml:"crls"`      // PEM or DER CRL files issued by the client CA
+	Deny     []string `yaml:"deny"`      // SHA-256 fingerprints of denied client certs
+	DenyFile string   `yaml:"deny_file"` // one fingerprint per line, # starts a comment
+
+	// How often to re-read the CRLs and deny file; 0 disables reloading
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+func (c *revocationConfig) enabled() bool {
+	return len(c.CRLs) > 0 || len(c.Deny) > 0 || c.DenyFile != ""
+}
+
+func (c *revocationConfig) validate() error {
+	var errs []error
+	for i, path := range c.CRLs {
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, fmt.Errorf("revocation.crls[%d]: %w", i, err))
+		}
+	}
+	for i, fp := range c.Deny {
+		if !validFingerprint(fp) {
+			errs = append(errs, fmt.Errorf("revocation.deny[%d]: must be a hex SHA-256 digest", i))
+		}
+	}
+	if c.DenyFile != "" {
+		if _, err := os.Stat(c.DenyFile); err != nil {
+			errs = append(errs, fmt.Errorf("revocation.deny_file: %w", err))
+		}
+	}
+	if c.ReloadInterval < 0 {
+		errs = append(errs, fmt.Errorf("revocation.reload_interval: must not be negative, got %s", c.ReloadInterval))
+	}
+	return errors.Join(errs...)
+}
+
+func validFingerprint(fp string) bool {
+	b, err := hex.DecodeString(normalizeFingerprint(fp))
+	return err == nil && len(b) == sha256.Size
+}
+
+// revocationChecker rejects client certificates that appear in a CRL or on
+// the deny list. It runs as tls.Config.VerifyConnection, i.e. after the chain
+// has been verified against the client CA, and unlike VerifyPeerCertificate
+// also on resumed sessions, so a session ticket issued before a cert was
+// revoked can't be used to get around the check.
+type revocationChecker struct {
+	cfg     revocationConfig
+	caCerts func() []*x509.Certificate
+
+	mu      sync.RWMutex
+	revoked map[string]bool // issuer DN + serial
+	denied  map[string]bool // normalized fingerprint
+}
+
+func newRevocationChecker(cfg revocationConfig, caCerts func() []*x509.Certificate) (*revocationChecker, error) {
+	c := &revocationChecker{cfg: cfg, caCerts: caCerts}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *revocationChecker) verifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := cs.PeerCertificates[0]
+	fp := sha256.Sum256(cert.Raw)
+
+	c.mu.RLock()
+	denied := c.denied[hex.EncodeToString(fp[:])]
+	revoked := c.revoked[revocationKey(cert.RawIssuer, cert.SerialNumber.String())]
+	c.mu.RUnlock()
+
+	switch {
+	case denied:
+		slog.Warn("rejected denied cert", "cn", cert.Subject.CommonName, "fingerprint", hex.EncodeToString(fp[:8]))
+		return errors.New("client certificate is on the deny list")
+	case revoked:
+		slog.Warn("rejected revoked cert", "cn", cert.Subject.CommonName, "fingerprint", hex.EncodeToString(fp[:8]), "serial", cert.SerialNumber.String())
+		return errors.New("client certificate has been revoked")
+	}
+	return nil
+}
+
+func (c *revocationChecker) reload() error {
+	revoked := make(map[string]bool)
+	for _, path := range c.cfg.CRLs {
+		crls, err := c.loadCRLs(path)
+		if err != nil {
+			return fmt.Errorf("failed to load CRL %s: %w", path, err)
+		}
+		for _, crl := range crls {
+			for _, entry := range crl.RevokedCertificateEntries {
+				revoked[revocationKey(crl.RawIssuer, entry.SerialNumber.String())] = true
+			}
+			if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+				slog.Warn("CRL is stale", "path", path, "next_update", crl.NextUpdate.Format(time.RFC3339))
+			}
+		}
+	}
+
+	denied := make(map[string]bool)
+	for _, fp := range c.cfg.Deny {
+		denied[normalizeFingerprint(fp)] = true
+	}
+	if c.cfg.DenyFile != "" {
+		fps, err := readDenyFile(c.cfg.DenyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load deny file: %w", err)
+		}
+		for _, fp := range fps {
+			denied[fp] = true
+		}
+	}
+
+	c.mu.Lock()
+	c.revoked = revoked
+	c.denied = denied
+	c.mu.Unlock()
+
+	slog.Info("loaded revocation lists", "revoked_serials", len(revoked), "denied_fingerprints", len(denied))
+	return nil
+}
+
+// watch reloads the CRLs and deny file every interval, keeping the previous
+// lists if loading fails.
+func (c *revocationChecker) watch(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		if err := c.reload(); err != nil {
+			slog.Error("revocation reload failed, keeping previous lists", "error", err)
+		}
+	}
+}
+
+// loadCRLs parses a PEM bundle or a single DER CRL and checks that each one
+// is signed by a cert in the client CA bundle.
+func (c *revocationChecker) loadCRLs(path string) ([]*x509.RevocationList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ders [][]byte
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type == "X509 CRL" {
+				ders = append(ders, block.Bytes)
+			}
+		}
+		if len(ders) == 0 {
+			return nil, errors.New("no X509 CRL blocks found")
+		}
+	} else {
+		ders = [][]byte{data}
+	}
+
+	var crls []*x509.RevocationList
+	for _, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.checkIssuer(crl); err != nil {
+			return nil, err
+		}
+		crls = append(crls, crl)
+	}
+	return crls, nil
+}
+
+func (c *revocationChecker) checkIssuer(crl *x509.RevocationList) error {
+	for _, ca := range c.caCerts() {
+		if crl.CheckSignatureFrom(ca) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("CRL issued by %q is not signed by a configured CA", crl.Issuer)
+}
+
+func readDenyFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var fps []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !validFingerprint(line) {
+			return nil, fmt.Errorf("line %d: invalid fingerprint %q", n, line)
+		}
+		fps = append(fps, normalizeFingerprint(line))
+	}
+	return fps, scanner.Err()
+}
+
+func revocationKey(rawIssuer []byte, serial string) string {
+	return string(rawIssuer) + "/" + serial
+}
@@ -0,0 +1,80 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A client that resumes a TLS session must be rejected once its cert is
+// denied, even though resumption skips chain verification.
+func TestRevocationAppliesToResumedSessions(t *testing.T) {
+	dir := testPKI(t, "client")
+	denyFile := filepath.Join(dir, "deny.txt")
+	if err := os.WriteFile(denyFile, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testServerConfig(dir)
+	cfg.Revocation.DenyFile = denyFile
+	cfg.Revocation.ReloadInterval = 20 * time.Millisecond
+	url := startTestServer(t, cfg)
+
+	c := testClient(t, dir, "client")
+	// A new connection for every request, so every request is a handshake.
+	// Closing idle connections between requests isn't enough: the previous
+	// connection may not be back in the pool yet.
+	c.Transport.(*http.Transport).DisableKeepAlives = true
+	get := func() (*http.Response, error) {
+		resp, err := c.Get(url + "/stats")
+		if err == nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	for i := range 2 {
+		resp, err := get()
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: got %d, want 200", i, resp.StatusCode)
+		}
+		if i == 1 && !resp.TLS.DidResume {
+			t.Fatal("second connection did not resume the TLS session")
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := sha256.Sum256(cert.Leaf.Raw)
+	if err := os.WriteFile(denyFile, []byte(hex.EncodeToString(fp[:])+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := get()
+		if err != nil {
+			return
+		}
+		if !resp.TLS.DidResume {
+			t.Fatal("connection did not resume the TLS session")
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("denied cert still accepted on a resumed session: got %d", resp.StatusCode)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -18,8 +18,12 @@ func signalNotify(c chan<- os.Signal) {
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 }
 
-func runService(cfg serverConfig, server *http.Server) error {
-	return runInteractive(cfg, server)
+func reloadNotify(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGHUP)
+}
+
+func runService(cfg serverConfig, server *http.Server, bans *banList) error {
+	return runInteractive(cfg, server, bans)
 }
 
 func serviceInstall(_ []string) {
@@ -7,12 +7,16 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/sys/windows/svc"
@@ -26,13 +30,17 @@ func signalNotify(c chan<- os.Signal) {
 	signal.Notify(c, os.Interrupt)
 }
 
-func runService(cfg serverConfig, server *http.Server) error {
+// reloadNotify is a no-op on Windows, which has no SIGHUP; cert changes are
+// picked up by polling instead.
+func reloadNotify(_ chan<- os.Signal) {}
+
+func runService(cfg serverConfig, server *http.Server, bans *banList) error {
 	isService, err := svc.IsWindowsService()
 	if err != nil {
 		return fmt.Errorf("failed to detect service mode: %w", err)
 	}
 	if !isService {
-		return runInteractive(cfg, server)
+		return runInteractive(cfg, server, bans)
 	}
 
 	elog, err := eventlog.Open(serviceName)
@@ -41,46 +49,45 @@ func runService(cfg serverConfig, server *http.Server) error {
 	}
 	defer elog.Close()
 
-	log.SetOutput(&eventLogWriter{elog: elog})
+	// An explicitly configured log file takes precedence over the event log
+	if cfg.Log.File == "" {
+		slog.SetDefault(slog.New(contextHandler{newEventLogHandler(elog, cfg.Log)}))
+	}
 
-	return svc.Run(serviceName, &winshutService{cfg: cfg, server: server})
+	return svc.Run(serviceName, &winshutService{cfg: cfg, server: server, bans: bans})
 }
 
 type winshutService struct {
 	cfg    serverConfig
 	server *http.Server
+	bans   *banList
 }
 
 func (s *winshutService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
 	changes <- svc.Status{State: svc.StartPending}
 
-	errCh := make(chan error, 1)
-	go func() {
-		if err := s.server.ListenAndServeTLS(s.cfg.CertFile, s.cfg.KeyFile); err != http.ErrServerClosed {
-			errCh <- err
-		}
-		close(errCh)
-	}()
+	errCh, err := serve(s.cfg, s.server, s.bans)
+	if err != nil {
+		slog.Error("server error", "error", err)
+		return false, 1
+	}
 
 	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
-	log.Printf("service started on %s (dry-run=%v)", s.cfg.Addr, s.cfg.DryRun)
+	slog.Info("service started", "listen", s.cfg.Listen, "dry_run", s.cfg.DryRun)
 
 	for {
 		select {
 		case err := <-errCh:
-			if err != nil {
-				log.Printf("server error: %v", err)
-				return false, 1
-			}
-			return false, 0
+			slog.Error("server error", "error", err)
+			return false, 1
 		case c := <-r:
 			switch c.Cmd {
 			case svc.Stop, svc.Shutdown:
 				changes <- svc.Status{State: svc.StopPending}
-				log.Println("service stopping...")
+				slog.Info("service stopping")
 				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 				if err := s.server.Shutdown(ctx); err != nil {
-					log.Printf("shutdown error: %v", err)
+					slog.Error("shutdown error", "error", err)
 				}
 				cancel()
 				return false, 0
@@ -91,16 +98,44 @@ func (s *winshutService) Execute(args []string, r <-chan svc.ChangeRequest, chan
 	}
 }
 
-type eventLogWriter struct {
-	elog *eventlog.Log
+// eventLogHandler formats records with the configured handler and writes
+// them to the Event Log as Information, Warning or Error by level.
+type eventLogHandler struct {
+	slog.Handler // writes into buf
+	elog         *eventlog.Log
+	mu           *sync.Mutex
+	buf          *bytes.Buffer
 }
 
-func (w *eventLogWriter) Write(p []byte) (int, error) {
-	err := w.elog.Info(1, string(p))
-	if err != nil {
-		return 0, err
+func newEventLogHandler(elog *eventlog.Log, cfg logConfig) *eventLogHandler {
+	buf := new(bytes.Buffer)
+	return &eventLogHandler{Handler: newFormatHandler(cfg, buf), elog: elog, mu: new(sync.Mutex), buf: buf}
+}
+
+func (h *eventLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buf.Reset()
+	if err := h.Handler.Handle(ctx, r); err != nil {
+		return err
 	}
-	return len(p), nil
+	msg := strings.TrimSuffix(h.buf.String(), "\n")
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.elog.Error(3, msg)
+	case r.Level >= slog.LevelWarn:
+		return h.elog.Warning(2, msg)
+	default:
+		return h.elog.Info(1, msg)
+	}
+}
+
+func (h *eventLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &eventLogHandler{Handler: h.Handler.WithAttrs(attrs), elog: h.elog, mu: h.mu, buf: h.buf}
+}
+
+func (h *eventLogHandler) WithGroup(name string) slog.Handler {
+	return &eventLogHandler{Handler: h.Handler.WithGroup(name), elog: h.elog, mu: h.mu, buf: h.buf}
 }
 
 func serviceInstall(args []string) {
@@ -0,0 +1,14 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+type systemStats struct {
+	CPUUsage      float64   `json:"cpu_usage_percent"`
+	MemoryTotal   uint64    `json:"memory_total_bytes"`
+	MemoryFree    uint64    `json:"memory_free_bytes"`
+	MemoryUsed    uint64    `json:"memory_used_bytes"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	LoadAverage   []float64 `json:"load_average,omitempty"` // 1, 5 and 15 minutes; Linux only
+}
@@ -0,0 +1,187 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// procRoot is where procfs is mounted. The parsing functions below take the
+// root as a parameter so they can be pointed at fixture files.
+const procRoot = "/proc"
+
+// cpuSampleInterval is how long getSystemStats waits between the two
+// /proc/stat samples it uses to compute CPU usage.
+const cpuSampleInterval = 200 * time.Millisecond
+
+func getSystemStats() (*systemStats, error) {
+	return readSystemStats(procRoot, cpuSampleInterval)
+}
+
+func readSystemStats(root string, sample time.Duration) (*systemStats, error) {
+	cpu, err := readCPUUsage(root, sample)
+	if err != nil {
+		return nil, err
+	}
+
+	total, available, err := readMemInfo(root)
+	if err != nil {
+		return nil, err
+	}
+
+	uptime, err := readUptime(root)
+	if err != nil {
+		return nil, err
+	}
+
+	load, err := readLoadAvg(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &systemStats{
+		CPUUsage:      cpu,
+		MemoryTotal:   total,
+		MemoryFree:    available,
+		MemoryUsed:    total - available,
+		UptimeSeconds: uptime,
+		LoadAverage:   load[:],
+	}, nil
+}
+
+// readCPUUsage samples /proc/stat twice, interval apart, and returns the
+// percentage of non-idle time across all CPUs in between.
+func readCPUUsage(root string, interval time.Duration) (float64, error) {
+	idle1, total1, err := readCPUTimes(root)
+	if err != nil {
+		return 0, err
+	}
+	time.Sleep(interval)
+	idle2, total2, err := readCPUTimes(root)
+	if err != nil {
+		return 0, err
+	}
+	if total2 <= total1 {
+		return 0, nil
+	}
+	busy := float64((total2-total1)-(idle2-idle1)) / float64(total2-total1) * 100
+	return max(0, min(100, busy)), nil
+}
+
+// readCPUTimes returns the idle (idle + iowait) and total jiffies from the
+// aggregate "cpu" line of /proc/stat.
+func readCPUTimes(root string) (idle, total uint64, err error) {
+	f, err := os.Open(filepath.Join(root, "stat"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		// user nice system idle iowait irq softirq steal guest guest_nice;
+		// guest time is already included in user and nice.
+		for i, field := range fields[1:min(len(fields), 9)] {
+			v, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("parsing /proc/stat: %w", err)
+			}
+			total += v
+			if i == 3 || i == 4 {
+				idle += v
+			}
+		}
+		return idle, total, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	return 0, 0, fmt.Errorf("parsing /proc/stat: no cpu line")
+}
+
+// readMemInfo returns MemTotal and MemAvailable from /proc/meminfo in bytes.
+func readMemInfo(root string) (total, available uint64, err error) {
+	f, err := os.Open(filepath.Join(root, "meminfo"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	// Output lines look like "MemTotal:       16318412 kB"
+	var haveTotal, haveAvailable bool
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || (key != "MemTotal" && key != "MemAvailable") {
+			continue
+		}
+		kb, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(rest), " kB"), 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("parsing /proc/meminfo %s: %w", key, err)
+		}
+		if key == "MemTotal" {
+			total, haveTotal = kb*1024, true
+		} else {
+			available, haveAvailable = kb*1024, true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	if !haveTotal || !haveAvailable {
+		return 0, 0, fmt.Errorf("parsing /proc/meminfo: missing MemTotal or MemAvailable")
+	}
+	return total, available, nil
+}
+
+// readUptime returns the seconds since boot from /proc/uptime.
+func readUptime(root string) (int64, error) {
+	data, err := os.ReadFile(filepath.Join(root, "uptime"))
+	if err != nil {
+		return 0, err
+	}
+	// Output: "350735.47 234388.90" (uptime, idle time)
+	fields := strings.Fields(string(data))
+	if len(fields) < 1 {
+		return 0, fmt.Errorf("parsing /proc/uptime: empty")
+	}
+	secs, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing /proc/uptime: %w", err)
+	}
+	return int64(secs), nil
+}
+
+// readLoadAvg returns the 1, 5 and 15 minute load averages from /proc/loadavg.
+func readLoadAvg(root string) ([3]float64, error) {
+	var load [3]float64
+	data, err := os.ReadFile(filepath.Join(root, "loadavg"))
+	if err != nil {
+		return load, err
+	}
+	// Output: "0.15 0.19 0.16 2/72 19279"
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return load, fmt.Errorf("parsing /proc/loadavg: expected 3 fields, got %d", len(fields))
+	}
+	for i := range load {
+		if load[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return load, fmt.Errorf("parsing /proc/loadavg: %w", err)
+		}
+	}
+	return load, nil
+}
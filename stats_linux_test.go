@@ -0,0 +1,119 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const fixtureProc = "testdata/proc"
+
+func TestReadSystemStats(t *testing.T) {
+	// Both samples read the same file, so no time passes and CPU usage is 0
+	stats, err := readSystemStats(fixtureProc, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := systemStats{
+		CPUUsage:      0,
+		MemoryTotal:   16318412 * 1024,
+		MemoryFree:    8159206 * 1024,
+		MemoryUsed:    (16318412 - 8159206) * 1024,
+		UptimeSeconds: 350735,
+		LoadAverage:   []float64{0.15, 0.19, 0.16},
+	}
+	if stats.CPUUsage != want.CPUUsage || stats.MemoryTotal != want.MemoryTotal || stats.MemoryFree != want.MemoryFree ||
+		stats.MemoryUsed != want.MemoryUsed || stats.UptimeSeconds != want.UptimeSeconds || !slices.Equal(stats.LoadAverage, want.LoadAverage) {
+		t.Errorf("got %+v, want %+v", *stats, want)
+	}
+}
+
+func TestReadCPUTimes(t *testing.T) {
+	idle, total, err := readCPUTimes(fixtureProc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// user..steal only; guest and guest_nice are already in user and nice
+	if idle != 400+50 || total != 100+20+30+400+50+6+7+8 {
+		t.Errorf("got idle %d, total %d; want 450, 621", idle, total)
+	}
+}
+
+// writeProc writes a single procfs file into a temporary root and returns
+// the root.
+func writeProc(t *testing.T, name, content string) string {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestProcParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		read    func(root string) error
+		want    string
+	}{
+		{
+			name:    "meminfo without MemAvailable",
+			file:    "meminfo",
+			content: "MemTotal:       16318412 kB\nMemFree:         1024000 kB\n",
+			read:    func(root string) error { _, _, err := readMemInfo(root); return err },
+			want:    "missing MemTotal or MemAvailable",
+		},
+		{
+			name:    "meminfo with a bad value",
+			file:    "meminfo",
+			content: "MemTotal:       lots kB\nMemAvailable:    8159206 kB\n",
+			read:    func(root string) error { _, _, err := readMemInfo(root); return err },
+			want:    "MemTotal",
+		},
+		{
+			name:    "stat without a cpu line",
+			file:    "stat",
+			content: "cpu0 50 10 15 200 25 3 4 4 5 5\nintr 123456 0 0 0\n",
+			read:    func(root string) error { _, _, err := readCPUTimes(root); return err },
+			want:    "no cpu line",
+		},
+		{
+			name:    "short loadavg",
+			file:    "loadavg",
+			content: "0.15 0.19\n",
+			read:    func(root string) error { _, err := readLoadAvg(root); return err },
+			want:    "expected 3 fields, got 2",
+		},
+		{
+			name:    "empty uptime",
+			file:    "uptime",
+			content: "\n",
+			read:    func(root string) error { _, err := readUptime(root); return err },
+			want:    "empty",
+		},
+		{
+			name: "missing file",
+			file: "other",
+			read: func(root string) error { _, err := readLoadAvg(root); return err },
+			want: "no such file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.read(writeProc(t, tt.file, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
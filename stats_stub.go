@@ -2,7 +2,7 @@
 // License, v. 2.0. If a copy of the MPL was not distributed with this
 // file, You can obtain one at https://mozilla.org/MPL/2.0/.
 
-//go:build !windows
+//go:build !windows && !linux
 
 package main
 
@@ -13,14 +13,6 @@ import (
 
 var startTime = time.Now()
 
-type systemStats struct {
-	CPUUsage      float64 `json:"cpu_usage_percent"`
-	MemoryTotal   uint64  `json:"memory_total_bytes"`
-	MemoryFree    uint64  `json:"memory_free_bytes"`
-	MemoryUsed    uint64  `json:"memory_used_bytes"`
-	UptimeSeconds int64   `json:"uptime_seconds"`
-}
-
 func getSystemStats() (*systemStats, error) {
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
@@ -13,14 +13,6 @@ import (
 	"time"
 )
 
-type systemStats struct {
-	CPUUsage      float64 `json:"cpu_usage_percent"`
-	MemoryTotal   uint64  `json:"memory_total_bytes"`
-	MemoryFree    uint64  `json:"memory_free_bytes"`
-	MemoryUsed    uint64  `json:"memory_used_bytes"`
-	UptimeSeconds int64   `json:"uptime_seconds"`
-}
-
 func getSystemStats() (*systemStats, error) {
 	cpu, err := getCPUUsage()
 	if err != nil {
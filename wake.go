@@ -0,0 +1,139 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/tomoconnor/winshut/wol"
+)
+
+// wakeConfig lists the machines this server may wake on behalf of clients
+// that can't reach their broadcast domain themselves.
+type wakeConfig struct {
+	Interface string              `yaml:"interface"` // default interface for magic packets
+	Peers     map[string]wakePeer `yaml:"peers"`
+}
+
+type wakePeer struct {
+	MAC       string `yaml:"mac"`
+	Broadcast string `yaml:"broadcast"` // default: the interface's subnet broadcast
+	SecureOn  string `yaml:"secureon"`
+	Interface string `yaml:"interface"` // overrides wake.interface
+}
+
+func (c *wakeConfig) validate() error {
+	var errs []error
+	if c.Interface != "" {
+		if err := wol.CheckInterface(c.Interface); err != nil {
+			errs = append(errs, fmt.Errorf("wake.interface: %w", err))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Peers)) {
+		p := c.Peers[name]
+		if _, err := wol.ParseMAC(p.MAC); err != nil {
+			errs = append(errs, fmt.Errorf("wake.peers.%s.mac: %w", name, err))
+		}
+		if _, err := wol.ParsePassword(p.SecureOn); err != nil {
+			errs = append(errs, fmt.Errorf("wake.peers.%s.secureon: %w", name, err))
+		}
+		if p.Interface != "" {
+			if err := wol.CheckInterface(p.Interface); err != nil {
+				errs = append(errs, fmt.Errorf("wake.peers.%s.interface: %w", name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// findPeer returns the peer named by the peer form value, or the one whose
+// MAC matches the mac form value.
+func (c *wakeConfig) findPeer(r *http.Request) (string, wakePeer, error) {
+	if name := r.FormValue("peer"); name != "" {
+		p, ok := c.Peers[name]
+		if !ok {
+			return "", p, fmt.Errorf("unknown peer %q", name)
+		}
+		return name, p, nil
+	}
+	v := r.FormValue("mac")
+	if v == "" {
+		return "", wakePeer{}, errors.New("peer or mac is required")
+	}
+	mac, err := wol.ParseMAC(v)
+	if err != nil {
+		return "", wakePeer{}, fmt.Errorf("invalid mac: %w", err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Peers)) {
+		if peerMAC, _ := wol.ParseMAC(c.Peers[name].MAC); bytes.Equal(peerMAC, mac) {
+			return name, c.Peers[name], nil
+		}
+	}
+	return "", wakePeer{}, fmt.Errorf("%s is not a configured peer", mac)
+}
+
+// wakeHandler serves POST /wake, sending a magic packet to a configured peer.
+func wakeHandler(cfg wakeConfig, audit *auditLog, dryRun bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, response{Status: "error", Message: "method not allowed"})
+			return
+		}
+
+		name, peer, err := cfg.findPeer(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, response{Status: "error", Message: err.Error()})
+			return
+		}
+		mac, _ := wol.ParseMAC(peer.MAC)
+		password, _ := wol.ParsePassword(peer.SecureOn)
+		iface := peer.Interface
+		if iface == "" {
+			iface = cfg.Interface
+		}
+
+		by := requesterFromRequest(r)
+		entry := auditEntry{
+			CN:          by.CN,
+			Fingerprint: by.Fingerprint,
+			RemoteAddr:  by.RemoteAddr,
+			RequestID:   by.RequestID,
+			Action:      "wake",
+			Params:      map[string]string{"peer": name, "mac": mac.String()},
+			DryRun:      dryRun,
+		}
+
+		if dryRun {
+			slog.InfoContext(r.Context(), "dry-run, not sending magic packet", "peer", name, "mac", mac.String(), "cn", by.CN)
+			metrics.inc("winshut_power_actions_total", "action", "wake", "result", "dry_run")
+			entry.Outcome = "succeeded"
+			audit.record(entry)
+			writeJSON(w, http.StatusOK, response{Status: "ok", Action: "wake", Message: "dry-run"})
+			return
+		}
+
+		addr, err := wol.Send(mac, password, peer.Broadcast, iface)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to send magic packet", "peer", name, "mac", mac.String(), "error", err)
+			metrics.inc("winshut_power_actions_total", "action", "wake", "result", "failed")
+			entry.Outcome, entry.Error = "failed", err.Error()
+			audit.record(entry)
+			writeJSON(w, http.StatusInternalServerError, response{Status: "error", Action: "wake", Message: "failed to send magic packet: " + err.Error()})
+			return
+		}
+
+		slog.InfoContext(r.Context(), "sent magic packet", "peer", name, "mac", mac.String(), "addr", addr, "cn", by.CN)
+		metrics.inc("winshut_power_actions_total", "action", "wake", "result", "executed")
+		entry.Outcome = "succeeded"
+		audit.record(entry)
+		writeJSON(w, http.StatusOK, response{Status: "ok", Action: "wake", Message: fmt.Sprintf("magic packet for %s sent to %s", name, addr)})
+	}
+}
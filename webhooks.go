@@ -0,0 +1,195 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+)
+
+const (
+	webhookQueueSize   = 100
+	webhookMaxAttempts = 5
+	webhookMinBackoff  = time.Second
+	webhookMaxBackoff  = 30 * time.Second
+)
+
+// webhookEvents are the events a webhook can subscribe to.
+var webhookEvents = []string{"accepted", "executed", "failed", "cancelled", "inhibited"}
+
+type webhookConfig struct {
+	URL     string        `yaml:"url"`
+	Secret  string        `yaml:"secret"`  // HMAC-SHA256 key for X-Winshut-Signature
+	Events  []string      `yaml:"events"`  // default: all
+	Timeout time.Duration `yaml:"timeout"` // per attempt, default 10s
+}
+
+func validateWebhooks(hooks []webhookConfig) error {
+	var errs []error
+	for i, h := range hooks {
+		if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("webhooks[%d].url: must be an http or https URL, got %q", i, h.URL))
+		}
+		for j, ev := range h.Events {
+			if !slices.Contains(webhookEvents, ev) {
+				errs = append(errs, fmt.Errorf("webhooks[%d].events[%d]: unknown event %q (valid: %v)", i, j, ev, webhookEvents))
+			}
+		}
+		if h.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("webhooks[%d].timeout: must not be negative, got %s", i, h.Timeout))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// webhookEvent is the JSON body posted to webhooks.
+type webhookEvent struct {
+	Event       string            `json:"event"`
+	Host        string            `json:"host"`
+	Action      string            `json:"action"`
+	JobID       string            `json:"job_id"`
+	Params      map[string]string `json:"params,omitempty"`
+	DryRun      bool              `json:"dry_run"`
+	CN          string            `json:"cn,omitempty"`
+	Fingerprint string            `json:"fingerprint,omitempty"`
+	RemoteAddr  string            `json:"remote_addr,omitempty"`
+	RequestID   string            `json:"request_id,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	Time        time.Time         `json:"time"`
+}
+
+// webhookNotifier delivers events to every subscribed webhook. Each webhook
+// has its own bounded queue and worker, so a slow receiver delays neither
+// requests nor other webhooks; events that don't fit are dropped. A nil
+// *webhookNotifier discards events.
+type webhookNotifier struct {
+	hostname string
+	hooks    []*webhook
+}
+
+type webhook struct {
+	webhookConfig
+	client *http.Client
+	queue  chan webhookDelivery
+}
+
+type webhookDelivery struct {
+	event string
+	body  []byte
+}
+
+func newWebhookNotifier(hooks []webhookConfig) *webhookNotifier {
+	if len(hooks) == 0 {
+		return nil
+	}
+	hostname, _ := os.Hostname()
+	n := &webhookNotifier{hostname: hostname}
+	for _, cfg := range hooks {
+		if len(cfg.Events) == 0 {
+			cfg.Events = webhookEvents
+		}
+		if cfg.Timeout == 0 {
+			cfg.Timeout = 10 * time.Second
+		}
+		h := &webhook{
+			webhookConfig: cfg,
+			client:        &http.Client{Timeout: cfg.Timeout},
+			queue:         make(chan webhookDelivery, webhookQueueSize),
+		}
+		go h.run()
+		n.hooks = append(n.hooks, h)
+	}
+	return n
+}
+
+// notify queues ev for every webhook subscribed to it without blocking.
+func (n *webhookNotifier) notify(ev webhookEvent) {
+	if n == nil {
+		return
+	}
+	ev.Host = n.hostname
+	ev.Time = time.Now().UTC()
+	body, err := json.Marshal(ev)
+	if err != nil {
+		slog.Error("failed to encode webhook event", "error", err)
+		return
+	}
+	for _, h := range n.hooks {
+		if !slices.Contains(h.Events, ev.Event) {
+			continue
+		}
+		select {
+		case h.queue <- webhookDelivery{event: ev.Event, body: body}:
+		default:
+			slog.Warn("webhook queue full, dropping event", "url", h.URL, "event", ev.Event, "job", ev.JobID)
+			metrics.inc("winshut_webhook_deliveries_total", "result", "dropped")
+		}
+	}
+}
+
+func (h *webhook) run() {
+	for d := range h.queue {
+		result := "delivered"
+		if err := h.deliver(d); err != nil {
+			slog.Error("webhook delivery failed", "url", h.URL, "attempts", webhookMaxAttempts, "error", err)
+			result = "failed"
+		}
+		metrics.inc("winshut_webhook_deliveries_total", "result", result)
+	}
+}
+
+// deliver posts d, retrying with exponential backoff on errors and non-2xx
+// responses.
+func (h *webhook) deliver(d webhookDelivery) error {
+	backoff := webhookMinBackoff
+	var err error
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		if err = h.post(d); err == nil {
+			return nil
+		}
+		if attempt < webhookMaxAttempts {
+			slog.Warn("webhook delivery failed, retrying", "url", h.URL, "attempt", attempt, "retry_in", backoff, "error", err)
+			time.Sleep(backoff)
+			backoff = min(backoff*2, webhookMaxBackoff)
+		}
+	}
+	return err
+}
+
+func (h *webhook) post(d webhookDelivery) error {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "winshut")
+	req.Header.Set("X-Winshut-Event", d.event)
+	if h.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(h.Secret))
+		mac.Write(d.body)
+		req.Header.Set("X-Winshut-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package wol builds and sends Wake-on-LAN magic packets.
+package wol
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DefaultAddr is where magic packets are sent when no broadcast address is
+// configured.
+const DefaultAddr = "255.255.255.255:9"
+
+// ParseMAC parses a 48-bit MAC address in any form net.ParseMAC accepts.
+func ParseMAC(s string) (net.HardwareAddr, error) {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(mac) != 6 {
+		return nil, fmt.Errorf("MAC address %q is not 48 bits", s)
+	}
+	return mac, nil
+}
+
+// ParsePassword parses a SecureOn password, given either like a MAC address
+// (6 bytes) or like an IPv4 address (4 bytes). An empty string means none.
+func ParsePassword(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if ip := net.ParseIP(s).To4(); ip != nil && strings.Count(s, ".") == 3 {
+		return ip, nil
+	}
+	if mac, err := ParseMAC(s); err == nil {
+		return mac, nil
+	}
+	return nil, fmt.Errorf("SecureOn password %q must be 6 bytes like a MAC address or 4 bytes like an IPv4 address", s)
+}
+
+// MagicPacket returns six 0xFF bytes, the MAC repeated 16 times, and the
+// SecureOn password if there is one.
+func MagicPacket(mac net.HardwareAddr, password []byte) []byte {
+	packet := bytes.Repeat([]byte{0xff}, 6)
+	for range 16 {
+		packet = append(packet, mac...)
+	}
+	return append(packet, password...)
+}
+
+// Send sends a magic packet for mac to addr, a UDP broadcast address with an
+// optional port (default 9). If iface is set, the packet leaves from that
+// interface's IPv4 address and addr defaults to the interface's subnet
+// broadcast address; otherwise addr defaults to DefaultAddr. It returns the
+// address the packet was sent to.
+func Send(mac net.HardwareAddr, password []byte, addr, iface string) (string, error) {
+	var laddr *net.UDPAddr
+	if iface != "" {
+		ipnet, err := interfaceIPv4(iface)
+		if err != nil {
+			return "", err
+		}
+		laddr = &net.UDPAddr{IP: ipnet.IP}
+		if addr == "" {
+			addr = broadcastAddr(ipnet).String()
+		}
+	}
+	if addr == "" {
+		addr = DefaultAddr
+	} else if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "9")
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return "", err
+	}
+	conn, err := net.DialUDP("udp4", laddr, raddr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	_, err = conn.Write(MagicPacket(mac, password))
+	return addr, err
+}
+
+// CheckInterface reports whether iface exists and has an IPv4 address.
+func CheckInterface(iface string) error {
+	_, err := interfaceIPv4(iface)
+	return err
+}
+
+func interfaceIPv4(name string) (*net.IPNet, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("no network interface %q", name)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return &net.IPNet{IP: ipnet.IP.To4(), Mask: ipnet.Mask[len(ipnet.Mask)-4:]}, nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no IPv4 address", name)
+}
+
+func broadcastAddr(n *net.IPNet) net.IP {
+	ip := make(net.IP, 4)
+	for i := range ip {
+		ip[i] = n.IP[i] | ^n.Mask[i]
+	}
+	return ip
+}
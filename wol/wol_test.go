@@ -0,0 +1,102 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package wol
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseMAC(t *testing.T) {
+	for _, s := range []string{"00:1a:2b:3c:4d:5e", "00-1A-2B-3C-4D-5E", "001a.2b3c.4d5e"} {
+		mac, err := ParseMAC(s)
+		if err != nil || mac.String() != "00:1a:2b:3c:4d:5e" {
+			t.Errorf("ParseMAC(%q) = %v, %v", s, mac, err)
+		}
+	}
+	for _, s := range []string{"", "00:1a:2b:3c:4d", "00:1a:2b:3c:4d:5e:6f:70", "render-01"} {
+		if _, err := ParseMAC(s); err == nil {
+			t.Errorf("ParseMAC(%q) succeeded", s)
+		}
+	}
+}
+
+func TestParsePassword(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []byte
+	}{
+		{"", nil},
+		{"192.168.1.10", []byte{192, 168, 1, 10}},
+		{"01:02:03:04:05:06", []byte{1, 2, 3, 4, 5, 6}},
+	}
+	for _, tt := range tests {
+		got, err := ParsePassword(tt.in)
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("ParsePassword(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, s := range []string{"secret", "::1", "01:02:03"} {
+		if _, err := ParsePassword(s); err == nil {
+			t.Errorf("ParsePassword(%q) succeeded", s)
+		}
+	}
+}
+
+func TestMagicPacket(t *testing.T) {
+	mac, _ := ParseMAC("00:1a:2b:3c:4d:5e")
+	packet := MagicPacket(mac, []byte{1, 2, 3, 4})
+	if len(packet) != 6+16*6+4 {
+		t.Fatalf("got %d bytes, want 106", len(packet))
+	}
+	if !bytes.Equal(packet[:6], bytes.Repeat([]byte{0xff}, 6)) {
+		t.Errorf("got header % x", packet[:6])
+	}
+	for i := range 16 {
+		if got := packet[6+i*6 : 12+i*6]; !bytes.Equal(got, mac) {
+			t.Errorf("repetition %d: got % x", i, got)
+		}
+	}
+	if !bytes.Equal(packet[102:], []byte{1, 2, 3, 4}) {
+		t.Errorf("got password % x", packet[102:])
+	}
+}
+
+func TestSend(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	mac, _ := ParseMAC("00:1a:2b:3c:4d:5e")
+	addr, err := Send(mac, nil, conn.LocalAddr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != conn.LocalAddr().String() {
+		t.Errorf("got address %s, want %s", addr, conn.LocalAddr())
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 200)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], MagicPacket(mac, nil)) {
+		t.Errorf("received % x", buf[:n])
+	}
+}
+
+func TestBroadcastAddr(t *testing.T) {
+	_, n, _ := net.ParseCIDR("192.168.1.10/24")
+	n.IP = net.IPv4(192, 168, 1, 10).To4()
+	if got := broadcastAddr(n).String(); got != "192.168.1.255" {
+		t.Errorf("got %s, want 192.168.1.255", got)
+	}
+}